@@ -5,13 +5,17 @@
 package bellt
 
 import (
-	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
+	pathpkg "path"
+	"reflect"
 	"regexp"
+	"sort"
 	"strings"
+	"sync"
 )
 
 var (
@@ -22,23 +26,117 @@ var (
 		"POST",
 		"PUT",
 		"DELETE",
+		"PATCH",
+		"HEAD",
+		"OPTIONS",
+		"CONNECT",
+		"TRACE",
+	}
+
+	// Signatures accepted as middlewares, through functions of any type
+	// declared with them.
+	middlewareForms = []reflect.Type{
+		reflect.TypeOf(Middleware(nil)),
+		reflect.TypeOf((func(http.Handler) http.Handler)(nil)),
+	}
+
+	// Types available to every Router for constrained parameters, used as
+	// "{name:type}" in route paths.
+	constraints = map[string]func(string) bool{
+		"int":   regexp.MustCompile(`^-?[0-9]+$`).MatchString,
+		"alpha": regexp.MustCompile(`^[a-zA-Z]+$`).MatchString,
+		"uuid": regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-` +
+			`[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`).MatchString,
 	}
-	mainRouter *Router
 )
 
 // Router is a struct responsible for storing routes already available (Route)
-// or routes that will still be available (BuiltRoute).
+// or routes that will still be available (BuiltRoute). It implements
+// http.Handler, so each instance can be served on its own, and routes may be
+// registered while it is serving requests.
+//
+// The Routers returned by Host, Route, Group and the matcher methods only
+// scope the registration of routes on the Router they derive from, called
+// its base Router, which serves every request. Their exported fields are
+// never read, so setting any of them is reported by Err once routes are
+// registered through them, and ExtendMethods and RegisterConstraint change
+// the base Router.
 type Router struct {
-	routes []*Route
-	built  []*BuiltRoute
+	// HandleOPTIONS enables automatic replies to OPTIONS requests, with the
+	// Allow header computed from the routes registered for the path.
+	HandleOPTIONS bool
+
+	// HandleHEAD enables serving HEAD requests through the GET handler of the
+	// path, discarding the response body.
+	HandleHEAD bool
+
+	// TrailingSlash defines how a path that matches a route only when a
+	// trailing slash is added or removed is handled. Strict by default.
+	TrailingSlash PathPolicy
+
+	// CleanPath defines how a path with repeated slashes or "." and ".."
+	// segments is handled. Strict by default, matching the path as received.
+	CleanPath PathPolicy
+
+	// LetterCase defines how a path that matches a route only when its static
+	// text is compared case-insensitively is handled. Parameter values are
+	// always kept as received. Strict by default.
+	LetterCase PathPolicy
+
+	// NotFound is called when no route matches the request path. A JSON
+	// message is answered with 404 when it is nil.
+	NotFound http.Handler
+
+	// MethodNotAllowed is called when the request path matches only routes
+	// registered for other methods, after the Allow header is set. A JSON
+	// message is answered with 405 when it is nil.
+	MethodNotAllowed http.Handler
+
+	mu          sync.RWMutex
+	version     int
+	pre         []layer
+	preHandler  http.HandlerFunc
+	preVersion  int
+	preBuild    sync.Mutex
+	routes      []*Route
+	names       map[string]*Route
+	built       []*BuiltRoute
+	tree        node
+	extensions  []string
+	constraints map[string]func(string) bool
+	errs        []error
+
+	parent     *Router
+	prefix     string
+	host       string
+	matchers   []*matcher
+	middleware []layer
+	err        error
+	reported   bool
 }
 
-// Route is a struct responsible for storing basic information of a Route, with
-// all its variable parameters recorded.
+// Route is a struct responsible for storing basic information of a Route and
+// the methods it answers to. Routes with variable parameters also keep their
+// BuiltRoute.
 type Route struct {
-	Path    string
-	Handler http.HandlerFunc
-	Params  []Variable
+	Path     string
+	Handler  http.HandlerFunc
+	methods  []string
+	tokens   []token
+	host     *hostPattern
+	matchers []*matcher
+	built    *BuiltRoute
+	name     string
+	group    string
+	meta     map[string]interface{}
+	use      []layer
+	handler  http.HandlerFunc
+	version  int
+	build    sync.Mutex
+	mount    bool
+	scope    *Router
+	router   *Router
+	static   *routeContext
 }
 
 // SubHandle is a struct similar to Route, however its behavior must be related
@@ -47,10 +145,14 @@ type SubHandle struct {
 	Path    string
 	Handler http.HandlerFunc
 	Methods []string
+	name    string
+	meta    map[string]interface{}
+	use     []interface{}
 }
 
 // BuiltRoute is an internal pattern struct for routes that will be built at
-// run time.
+// run time. Var only records the names of the variables by position; values
+// are never written to it.
 type BuiltRoute struct {
 	TempPath string
 	Handler  http.HandlerFunc
@@ -72,88 +174,354 @@ type ParamReceiver struct {
 }
 
 // Middleware is a type responsible for characterizing middleware functions
-// that should be used in conjunction with bellt.Use().
+// that should be used in conjunction with bellt.Use(). Wherever middlewares
+// are accepted, the standard form func(http.Handler) http.Handler may be used
+// as well, both forms being composable in the same chain.
 type Middleware func(http.HandlerFunc) http.HandlerFunc
 
-// Key is a type responsible for define a requester key param
-type key string
+// layer is a middleware converted to the Middleware form, along with the name
+// of the function it was given as.
+type layer struct {
+	name string
+	wrap Middleware
+}
+
+// PathPolicy defines how the Router handles request paths that only match a
+// route once brought to their canonical form.
+type PathPolicy int
 
-// NewRouter is responsible to initialize a "singleton" router instance.
+// Policies available for TrailingSlash, CleanPath and LetterCase.
+const (
+	// PathStrict matches the path exactly as received.
+	PathStrict PathPolicy = iota
+
+	// PathRedirect redirects to the canonical path, with 301 for GET and HEAD
+	// requests and 308 for the other methods.
+	PathRedirect
+
+	// PathEquivalent serves the route of the canonical path directly.
+	PathEquivalent
+)
+
+// Key of the matched route in the request context.
+type routeKey struct{}
+
+// routeContext is the value held by the request context for the matched
+// route, along with its variables, the ones of the routes the request was
+// matched through first when it is served by a mounted Router.
+type routeContext struct {
+	route  *Route
+	params []Variable
+}
+
+// List of errors found while registering routes, reported by Router.Err().
+type registrationErrors []error
+
+// NewRouter is responsible to initialize a new router instance. Every call
+// returns an independent Router, with no side effects on http.DefaultServeMux.
 func NewRouter() *Router {
-	if mainRouter == nil {
-		http.HandleFunc("/health", healthApplication)
-		http.HandleFunc("/", redirectBuiltRoute)
-		mainRouter = &Router{}
-	}
-	return mainRouter
+	return &Router{}
 }
 
 /*
 	Router is a struct responsible for storing routes already available (Route)
 	or routes that will still be available (BuiltRoute).
 
-	Its initialization is done through the method NewRouter:
+	Its initialization is done through the method NewRouter, and the router
+	itself must be passed as the server handler:
 
-		router: = bellt.NewRouter ()
+		router := bellt.NewRouter()
 
-		func main () {
+		func main() {
 			[...]
-			log.Fatal (http.ListenAndServe (": 8080", nil))
+			log.Fatal(http.ListenAndServe(":8080", router))
 		}
 */
 
-// Method to obtain router for interanl processing
-func getRouter() *Router {
-	return mainRouter
+// ServeHTTP dispatches the request to the route matching its path and method.
+// Routes are resolved through the route tree; a path registered only for other
+// methods is answered with 405, and a path no route matches with 404. The
+// path is first brought to its canonical form according to the CleanPath,
+// TrailingSlash and LetterCase policies. The middlewares added by Pre run
+// before all of it.
+func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r = r.base()
+	r.mu.RLock()
+	pre := r.preHandler
+	fresh := len(r.pre) == 0 || pre != nil && r.preVersion == r.version
+	r.mu.RUnlock()
+
+	if !fresh {
+		pre = r.composePre()
+	}
+	if pre != nil {
+		pre(w, req)
+		return
+	}
+	r.serve(w, req)
 }
 
-// RedirectBuiltRoute Performs code analysis assigning values to variables
-// in execution time.
-func redirectBuiltRoute(w http.ResponseWriter, r *http.Request) {
-	selectedBuilt, params := getRequestParams(r.URL.Path)
-
-	if selectedBuilt != nil {
-		router := getRouter()
-		for idx, varParam := range selectedBuilt.Var {
-			selectedBuilt.Var[idx] = Variable{
-				Name:  varParam.Name,
-				Value: params[idx],
+// Internal method that matches the request and dispatches it, as described by
+// ServeHTTP.
+func (r *Router) serve(w http.ResponseWriter, req *http.Request) {
+	path := req.URL.Path
+	m := match{req: req, method: req.Method}
+	redirect := false
+
+	r.mu.RLock()
+	if r.CleanPath != PathStrict {
+		if clean := cleanPath(path); clean != path {
+			path, redirect = clean, r.CleanPath == PathRedirect
+		}
+	}
+	path, canonical := r.resolve(path, &m)
+	redirect = redirect || canonical
+	found := m.route != nil
+	var handler http.HandlerFunc
+	fresh := true
+	if found {
+		handler = m.route.handler
+		fresh = handler != nil && m.route.version == r.version
+	}
+	r.mu.RUnlock()
+
+	if !fresh {
+		handler = r.compose(m.route)
+	}
+
+	if redirect && (found || len(m.allowed) > 0) {
+		redirectPath(w, req, path)
+		return
+	}
+	if m.head {
+		w = headResponseWriter{w}
+	}
+
+	switch {
+	case found:
+		r.redirectBuiltRoute(w, req, handler, m.route, &m)
+	case len(m.allowed) > 0 && r.HandleOPTIONS && req.Method == "OPTIONS":
+		w.Header().Set("Allow", r.allowHeader(m.allowed))
+		w.WriteHeader(http.StatusNoContent)
+	case len(m.allowed) > 0:
+		w.Header().Set("Allow", r.allowHeader(m.allowed))
+		if r.MethodNotAllowed != nil {
+			r.MethodNotAllowed.ServeHTTP(w, req)
+		} else {
+			methodNotAllowed(w, req)
+		}
+	case r.NotFound != nil:
+		r.NotFound.ServeHTTP(w, req)
+	default:
+		notFound(w, req)
+	}
+}
+
+// Internal method that finds the route for the path, trying the forms allowed
+// by the TrailingSlash and LetterCase policies when the path itself matches
+// nothing. It returns the path that matched, in its registered form, and
+// whether the request must be redirected to it.
+func (r *Router) resolve(path string, m *match) (string, bool) {
+	req, method := m.req, m.method
+	if r.find(path, m) || len(m.allowed) > 0 {
+		return path, false
+	}
+
+	alternative := ""
+	if r.TrailingSlash != PathStrict && path != "/" {
+		alternative = path + "/"
+		if strings.HasSuffix(path, "/") {
+			alternative = strings.TrimSuffix(path, "/")
+		}
+
+		*m = match{req: req, method: method}
+		if r.find(alternative, m) || len(m.allowed) > 0 {
+			return alternative, r.TrailingSlash == PathRedirect
+		}
+	}
+
+	if r.LetterCase != PathStrict {
+		for _, candidate := range []string{path, alternative} {
+			if candidate == "" {
+				continue
 			}
+
+			// The registered form of the path is only known once a route
+			// is found, so paths matching routes of other methods are
+			// answered as they are, without redirecting.
+			*m = match{req: req, method: method, fold: true}
+			if r.find(candidate, m) {
+				return string(m.canonical), r.LetterCase == PathRedirect ||
+					(candidate == alternative &&
+						r.TrailingSlash == PathRedirect)
+			}
+			if len(m.allowed) > 0 {
+				return candidate, false
+			}
+		}
+	}
+
+	*m = match{req: req, method: method}
+	return path, false
+}
+
+// Internal method that looks the path up for the method of the match. HEAD
+// requests fall back to the GET routes when HandleHEAD is set.
+func (r *Router) find(path string, m *match) bool {
+	if m.fold {
+		m.canonical = []byte(path)
+	}
+	if r.tree.lookup(path, m) {
+		return true
+	}
+	if r.HandleHEAD && m.method == "HEAD" && containsString(m.allowed, "GET") {
+		*m = match{req: m.req, method: "GET", head: true, fold: m.fold}
+		return r.find(path, m)
+	}
+	return false
+}
+
+// RedirectBuiltRoute Performs code analysis assigning values to variables
+// in execution time, the host variables followed by the path ones. Values are
+// resolved on every request and kept in the request context only, in a single
+// value along with the route reported by CurrentRoute, so neither the router
+// nor the route are changed. Requests without variables share the value kept
+// by the route.
+func (r *Router) redirectBuiltRoute(w http.ResponseWriter, req *http.Request,
+	handler http.HandlerFunc, route *Route, m *match) {
+	ctx := req.Context()
+	var inherited []Variable
+	if outer, ok := ctx.Value(routeKey{}).(*routeContext); ok {
+		inherited = outer.params
+	}
+
+	current := route.static
+	if size := len(inherited) + len(m.hostValues) + len(m.values); size > 0 {
+		params := make([]Variable, 0, size)
+		params = append(params, inherited...)
+		for idx, value := range m.hostValues {
+			params = append(params, Variable{
+				Name:  route.host.names[idx],
+				Value: value,
+			})
 		}
-		var allParams []Variable
-		for _, param := range selectedBuilt.Var {
-			allParams = append(allParams, param)
+		for idx, value := range m.values {
+			params = append(params, Variable{
+				Name:  route.built.Var[idx].Name,
+				Value: value,
+			})
 		}
-		router.createBuiltRoute(
-			selectedBuilt.TempPath,
-			selectedBuilt.Handler,
-			selectedBuilt.Methods,
-			selectedBuilt.Var)
+		current = &routeContext{route: route, params: params}
+	}
+
+	req = req.WithContext(context.WithValue(ctx, routeKey{}, current))
+	if route.mount {
+		req = stripPrefix(req, route, m)
+	}
+	handler.ServeHTTP(w, req)
+}
 
-		setRouteParams(gateMethod(
-			selectedBuilt.Handler,
-			selectedBuilt.Methods...),
-			allParams).ServeHTTP(w, r)
-	} else {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusNotFound)
-		w.Write([]byte(`{"msg": "route not found"}`))
+// Internal function that converts a function of a named type to the form of
+// middleware with the same signature, returning other values as given.
+func middlewareForm(mid interface{}) interface{} {
+	value := reflect.ValueOf(mid)
+	if value.Kind() != reflect.Func {
+		return mid
 	}
 
+	for _, form := range middlewareForms {
+		if value.Type() != form && value.Type().ConvertibleTo(form) {
+			return value.Convert(form).Interface()
+		}
+	}
+	return mid
 }
 
 // Use becomes responsible for executing all middlewares passed through a
-// cascade method.
-func Use(handler http.HandlerFunc, middleware ...Middleware) http.HandlerFunc {
+// cascade method. Each middleware is either a Middleware or a standard
+// func(http.Handler) http.Handler, in any order; Use panics when given
+// anything else.
+func Use(handler http.HandlerFunc, middleware ...interface{}) http.HandlerFunc {
+	layers, err := toLayers(middleware)
+	if err != nil {
+		panic(err)
+	}
+	return cascade(handler, layers)
+}
 
-	for x := len(middleware) - 1; x >= 0; x-- {
-		mid := middleware[x]
-		handler = mid(handler)
+// Internal function that wraps the handler with the middlewares, the first
+// one being the outermost.
+func cascade(handler http.HandlerFunc, layers []layer) http.HandlerFunc {
+
+	for x := len(layers) - 1; x >= 0; x-- {
+		mid := layers[x]
+		handler = mid.wrap(handler)
 	}
 
 	return handler
 }
 
+// Internal function that converts middlewares given in any of the accepted
+// forms to layers, failing on the first one of another type. Functions of
+// named types declared by other packages, such as the middleware types of
+// other routers, are accepted when their signature is one of the forms.
+func toLayers(middleware []interface{}) ([]layer, error) {
+	layers := make([]layer, 0, len(middleware))
+	for _, mid := range middleware {
+		var wrap Middleware
+		switch fn := middlewareForm(mid).(type) {
+		case Middleware:
+			wrap = fn
+		case func(http.HandlerFunc) http.HandlerFunc:
+			wrap = fn
+		case func(http.Handler) http.Handler:
+			if fn != nil {
+				wrap = func(next http.HandlerFunc) http.HandlerFunc {
+					return handlerFunc(fn(next))
+				}
+			}
+		default:
+			return nil, fmt.Errorf("Middleware of type %T is not supported",
+				mid)
+		}
+
+		if wrap == nil {
+			return nil, errors.New("Middleware must not be nil")
+		}
+		layers = append(layers, layer{name: funcName(mid), wrap: wrap})
+	}
+	return layers, nil
+}
+
+// Use adds middlewares to the route, executed in cascade around its handler
+// as done by bellt.Use, after the ones already added and the ones of the
+// Routers the route was registered through. Their names are listed by
+// Router.Routes, and middlewares of unsupported types are reported by
+// Router.Err.
+func (route *Route) Use(middleware ...interface{}) *Route {
+	if len(middleware) == 0 {
+		return route
+	}
+	if route.router != nil {
+		route.router.mu.Lock()
+		defer route.router.mu.Unlock()
+	}
+
+	layers, err := toLayers(middleware)
+	if err != nil {
+		if route.router != nil {
+			route.router.errs = append(route.router.errs, fmt.Errorf(
+				"Route %s: %v", route.pattern(), err))
+		}
+		return route
+	}
+	route.use = append(route.use, layers...)
+	if route.router != nil {
+		route.router.version++
+	}
+	return route
+}
+
 /*
 	The Use application should be done as follows within the
 	router.HandleFunc() method:
@@ -167,7 +535,7 @@ func Use(handler http.HandlerFunc, middleware ...Middleware) http.HandlerFunc {
 				middleware2,
 				...,
 			), "GET")
-			log.Fatal (http.ListenAndServe (": 8080", nil))
+			log.Fatal(http.ListenAndServe(":8080", router))
 		}
 */
 
@@ -177,43 +545,82 @@ func Use(handler http.HandlerFunc, middleware ...Middleware) http.HandlerFunc {
 
 // HandleFunc function responsible for initializing a common route or built
 // through the Router. All non-grouped routes must be initialized by this
-// method.
-func (r *Router) HandleFunc(path string, handleFunc http.HandlerFunc, methods ...string) {
-	key, values := getBuiltRouteParams(path)
-	if values != nil {
-		valuesList := make(map[int]Variable)
-
-		for idx, name := range values {
-			valuesList[idx] = Variable{
-				Name:  name[1],
-				Value: "",
-			}
-		}
-
-		builtRoute := &BuiltRoute{
-			TempPath: path,
-			Handler:  handleFunc,
-			Var:      valuesList,
-			KeyRoute: key,
-			Methods:  methods,
-		}
-
-		r.built = append(r.built, builtRoute)
+// method. The returned Route may be named for URL building; when registration
+// fails the error is reported by Err and naming the Route has no effect.
+func (r *Router) HandleFunc(path string, handleFunc http.HandlerFunc, methods ...string) *Route {
+	prefix := r.scopePrefix()
+	return r.handleFunc(prefix, joinPath(prefix, path), false, handleFunc,
+		methods...)
+}
 
-	} else {
+// Handle registers a route served by the handler, as done by HandleFunc.
+func (r *Router) Handle(path string, handler http.Handler, methods ...string) *Route {
+	prefix := r.scopePrefix()
+	return r.handleFunc(prefix, joinPath(prefix, path), false,
+		handlerFunc(handler), methods...)
+}
 
-		route := &Route{
-			Path:    path,
-			Handler: handleFunc,
-		}
-		err := route.methods(methods...)
+// HandleHealth registers the standard "/health" route, answering GET requests
+// with a JSON message telling the service is alive, so services built with
+// bellt may act as microservices. It is a route like any other, listed by
+// Routes and registered under the prefix of the Router.
+func (r *Router) HandleHealth() *Route {
+	return r.HandleFunc("/health", healthApplication, "GET")
+}
 
-		if err == nil {
-			r.routes = append(r.routes, route)
-		}
+// Mount registers the handler for every method on the prefix and on all the
+// paths below it, such as "/static" and "/static/css/site.css" for the prefix
+// "/static". The handler receives the request with the prefix stripped from
+// its path, which always starts with a slash, so it may be an http.FileServer
+// or another Router. The prefix may hold parameters, read through
+// RouteVariables, while the stripped part of the path is held by "*".
+//
+// Mounted handlers are matched as catch-all routes, so the routes registered
+// below the prefix take precedence, and run the middlewares of the Routers
+// they are mounted through:
+//
+//	router.Mount("/static", http.FileServer(http.Dir("public")))
+//	router.Route("/debug", func(debug *bellt.Router) {
+//		debug.Use(adminOnly)
+//		debug.Mount("/pprof", pprofHandler)
+//	})
+func (r *Router) Mount(prefix string, handler http.Handler) *Route {
+	group := r.scopePrefix()
+	return r.handleFunc(group, joinPath(group, prefix), true,
+		handlerFunc(handler))
+}
 
+// Internal function that returns the function serving requests through the
+// handler, nil when there is no handler.
+func handlerFunc(handler http.Handler) http.HandlerFunc {
+	if handler == nil {
+		return nil
 	}
+	if fn, ok := handler.(http.HandlerFunc); ok {
+		return fn
+	}
+	return handler.ServeHTTP
+}
 
+// Internal method that registers a route on the path, which holds the prefix
+// of its group, empty for routes declared outside a group. Mounted routes
+// answer every method below their path.
+func (r *Router) handleFunc(group, path string, mount bool,
+	handleFunc http.HandlerFunc, methods ...string) *Route {
+	root := r.base()
+	root.mu.Lock()
+	defer root.mu.Unlock()
+
+	r.checkSettings(path)
+	route, err := r.register(path, mount, handleFunc, methods...)
+	if err != nil {
+		root.errs = append(root.errs, err)
+		return &Route{Path: path, Handler: handleFunc,
+			methods: methods, group: group, mount: mount}
+	}
+	route.group = group
+	route.scope = r
+	return route
 }
 
 /*
@@ -229,20 +636,44 @@ func (r *Router) HandleFunc(path string, handleFunc http.HandlerFunc, methods ..
 				middleware2,
 				...,
 			), "GET")
-			log.Fatal (http.ListenAndServe (": 8080", nil))
+			log.Fatal(http.ListenAndServe(":8080", router))
 		}
 */
 
 // HandleGroup used to create and define a group of sub-routes
 func (r *Router) HandleGroup(mainPath string, sr ...*SubHandle) {
+	group := joinPath(r.scopePrefix(), mainPath)
 	for _, route := range sr {
-		var buf bytes.Buffer
-		buf.WriteString(mainPath)
-		buf.WriteString(route.Path)
-		r.HandleFunc(buf.String(), route.Handler, route.Methods...)
+		registered := r.handleFunc(group, group+route.Path, false,
+			route.Handler, route.Methods...).Use(route.use...)
+		for key, value := range route.meta {
+			registered.Meta(key, value)
+		}
+		if route.name != "" {
+			registered.Name(route.name)
+		}
 	}
 }
 
+// Err reports the problems found while registering routes through HandleFunc
+// and HandleGroup, such as invalid methods, malformed parameters, empty paths
+// or duplicate routes. It returns nil when every route was registered, and
+// should be checked once all routes are declared:
+//
+//	if err := router.Err(); err != nil {
+//		log.Fatal(err)
+//	}
+func (r *Router) Err() error {
+	r = r.base()
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if len(r.errs) == 0 {
+		return nil
+	}
+	return append(registrationErrors(nil), r.errs...)
+}
+
 // SubHandleFunc is responsible for initializing a common or built route. Its
 // use must be made within the scope of the HandleGroup() method, where the
 // main path will be declared.
@@ -257,128 +688,400 @@ func (r *Router) SubHandleFunc(path string, handleFunc http.HandlerFunc,
 	return handleDetail
 }
 
-// Internal method of route construction based on parameters passed in the
-// HandleFunc, guaranteeing a valid and functional route.
-func (r *Router) routeBuilder(path string, handleFunc http.HandlerFunc,
-	params ...Variable) *Route {
+// Name sets the name of the route registered by HandleGroup for the SubHandle,
+// as done by Route.Name.
+func (sh *SubHandle) Name(name string) *SubHandle {
+	sh.name = name
+	return sh
+}
+
+// Meta sets metadata on the route registered by HandleGroup for the
+// SubHandle, as done by Route.Meta.
+func (sh *SubHandle) Meta(key string, value interface{}) *SubHandle {
+	if sh.meta == nil {
+		sh.meta = make(map[string]interface{})
+	}
+	sh.meta[key] = value
+	return sh
+}
+
+// Use adds middlewares to the route registered by HandleGroup for the
+// SubHandle, as done by Route.Use.
+func (sh *SubHandle) Use(middleware ...interface{}) *SubHandle {
+	sh.use = append(sh.use, middleware...)
+	return sh
+}
+
+// Internal method responsible for validating a route and adding it to the
+// route tree of the base Router, scoped to the host and matchers of the
+// Router. Mounted routes are added below their path as a catch-all route, and
+// on the path itself.
+func (r *Router) register(path string, mount bool, handleFunc http.HandlerFunc,
+	methods ...string) (*Route, error) {
+	root := r.base()
+	if path == "" {
+		return nil, errors.New("Route path must not be empty")
+	}
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	if mount {
+		path = strings.TrimSuffix(path, "/") + "/*"
+	}
+	tokens, err := parsePattern("Route", path)
+	if err != nil {
+		return nil, err
+	}
+	for idx, tk := range tokens {
+		if tk.constraint == "" {
+			continue
+		}
+		if tokens[idx].check, err = root.compileConstraint("Route", path,
+			tk.constraint); err != nil {
+			return nil, err
+		}
+	}
+	host, err := r.hostPattern()
+	if err != nil {
+		return nil, err
+	}
+	matchers, err := r.scopeMatchers()
+	if err != nil {
+		return nil, err
+	}
+	if host != nil {
+		for _, tk := range tokens {
+			if tk.param && containsString(host.names, tk.text) {
+				return nil, fmt.Errorf("Route %s has the parameter {%s} of "+
+					"host %s", path, tk.text, host.text)
+			}
+		}
+	}
+	if handleFunc == nil {
+		return nil, fmt.Errorf("Route %s has no handler", path)
+	}
+	if len(methods) == 0 && !mount {
+		return nil, fmt.Errorf("Route %s has no methods", path)
+	}
+	if err := root.validateMethods(path, methods...); err != nil {
+		return nil, err
+	}
+
 	route := &Route{
-		Handler: handleFunc,
-		Path:    path,
-		Params:  params,
+		Path:     path,
+		Handler:  handleFunc,
+		methods:  methods,
+		tokens:   tokens,
+		host:     host,
+		matchers: matchers,
+		mount:    mount,
+		router:   root,
 	}
+	route.static = &routeContext{route: route}
 
-	r.routes = append(r.routes, route)
-	return route
+	leaves := []*node{root.tree.insert(tokens)}
+	if mount {
+		if exact := mountPrefix(tokens); len(exact) > 0 {
+			leaves = append(leaves, root.tree.insert(exact))
+		}
+	}
+	for _, leaf := range leaves {
+		if err := checkConflict(leaf, route); err != nil {
+			return nil, err
+		}
+	}
+
+	key, values := getBuiltRouteParams(tokens)
+	if values != nil {
+		valuesList := make(map[int]Variable)
+
+		for idx, name := range values {
+			valuesList[idx] = Variable{
+				Name:  name,
+				Value: "",
+			}
+		}
+
+		builtRoute := &BuiltRoute{
+			TempPath: path,
+			Handler:  handleFunc,
+			Var:      valuesList,
+			KeyRoute: key,
+			Methods:  methods,
+		}
+
+		root.built = append(root.built, builtRoute)
+		route.built = builtRoute
+	}
+
+	root.routes = append(root.routes, route)
+	for _, leaf := range leaves {
+		leaf.addRoute(route)
+	}
+	return route, nil
 }
 
-// Internal method responsible for standardizing built routes in order to
-// generate valid models of used.
-func (r *Router) createBuiltRoute(path string, handler http.HandlerFunc,
-	methods []string, params map[int]Variable) {
-	var (
-		builtPath = path
-		allParams []Variable
-	)
+// Internal function that returns the tokens of the path a mounted route is
+// mounted on, given the tokens of its catch-all pattern. They are empty for
+// routes mounted on the root path.
+func mountPrefix(tokens []token) []token {
+	exact := append([]token(nil), tokens[:len(tokens)-1]...)
+	last := &exact[len(exact)-1]
+	last.text = strings.TrimSuffix(last.text, "/")
+	if last.text == "" {
+		exact = exact[:len(exact)-1]
+	}
+	return exact
+}
 
-	for _, param := range params {
-		builtPath = strings.Replace(builtPath, "{"+param.Name+"}",
-			param.Value, -1)
-		allParams = append(allParams, param)
+// Internal function that checks whether a route clashes with the routes
+// already registered on the same tree node. Routes ending on the same node
+// match exactly the same paths, so sharing a method, a host pattern matching
+// the same hosts and matchers makes them duplicate or ambiguous.
+func checkConflict(leaf *node, route *Route) error {
+	for _, registered := range leaf.routes {
+		if registered.hostShape() != route.hostShape() ||
+			!sameMatchers(registered.matchers, route.matchers) {
+			continue
+		}
+		if registered.mount || route.mount {
+			return fmt.Errorf("Route %s conflicts with %s", route.pattern(),
+				registered.pattern())
+		}
+		for _, method := range route.methods {
+			if !containsString(registered.methods, method) {
+				continue
+			}
+			if registered.Path == route.Path && strings.EqualFold(
+				registered.hostText(), route.hostText()) {
+				return fmt.Errorf("Route %s %s is already registered",
+					method, route.pattern())
+			}
+			return fmt.Errorf("Route %s %s conflicts with %s %s",
+				method, route.pattern(), method, registered.pattern())
+		}
 	}
+	return nil
+}
 
-	r.routeBuilder(builtPath, handler, allParams...).methods(methods...)
+// Internal method that checks whether the route answers the method, which
+// mounted routes do for every method.
+func (route *Route) allows(method string) bool {
+	return route.mount || containsString(route.methods, method)
 }
 
-// ----------------------------------------------------------------------------
-// Route methods
-// ----------------------------------------------------------------------------
+// Internal method that returns the host pattern of the route, empty when it
+// answers any host.
+func (route *Route) hostText() string {
+	if route.host == nil {
+		return ""
+	}
+	return route.host.text
+}
+
+// Internal method that returns the host and path patterns of the route, as
+// shown in error messages.
+func (route *Route) pattern() string {
+	return route.hostText() + route.Path
+}
+
+// ExtendMethods allows extension methods, such as "PROPFIND" or "PURGE", to be
+// used by the routes of the Router in addition to the standard HTTP methods.
+func (r *Router) ExtendMethods(extensions ...string) error {
+	r = r.base()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, method := range extensions {
+		if !validToken(method) {
+			return fmt.Errorf("Method %q is not a valid HTTP method", method)
+		}
+	}
+	r.extensions = append(r.extensions, extensions...)
+	return nil
+}
+
+// Internal method that builds the Allow header from the methods registered for
+// a path, including the ones answered automatically by the Router.
+func (r *Router) allowHeader(allowed []string) string {
+	allowed = append([]string(nil), allowed...)
+	if r.HandleHEAD && containsString(allowed, "GET") &&
+		!containsString(allowed, "HEAD") {
+		allowed = append(allowed, "HEAD")
+	}
+	if r.HandleOPTIONS && !containsString(allowed, "OPTIONS") {
+		allowed = append(allowed, "OPTIONS")
+	}
+	sort.Strings(allowed)
+	return strings.Join(allowed, ", ")
+}
+
+// RegisterConstraint adds a custom type to the Router, so route parameters
+// declared as "{name:type}" only match the values accepted by the function.
+// Types must be registered before the routes using them.
+func (r *Router) RegisterConstraint(name string, match func(value string) bool) error {
+	if !paramName.MatchString(name) {
+		return fmt.Errorf("Constraint %q must be a word", name)
+	}
+	if match == nil {
+		return fmt.Errorf("Constraint %s has no match function", name)
+	}
+
+	r = r.base()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.constraints == nil {
+		r.constraints = make(map[string]func(string) bool)
+	}
+	r.constraints[name] = match
+	return nil
+}
+
+// Internal method that resolves the constraint of a parameter. Words refer to
+// the types of the Router or to the standard types, while anything else is
+// compiled as a regular expression that must match the whole value.
+func (r *Router) compileConstraint(kind, pattern, constraint string) (func(string) bool, error) {
+	if paramName.MatchString(constraint) {
+		if match, ok := r.constraints[constraint]; ok {
+			return match, nil
+		}
+		if match, ok := constraints[constraint]; ok {
+			return match, nil
+		}
+		return nil, fmt.Errorf("%s %s uses the unknown constraint %s",
+			kind, pattern, constraint)
+	}
+
+	rgx, err := regexp.Compile(`^(?:` + constraint + `)$`)
+	if err != nil {
+		return nil, fmt.Errorf("%s %s has an invalid constraint %s: %v",
+			kind, pattern, constraint, err)
+	}
+	return rgx.MatchString, nil
+}
 
 // Internal method responsible for validating if the request method used exists
 // for the route presented.
-func (r *Route) methods(methods ...string) (err error) {
+func (r *Router) validateMethods(path string, methods ...string) (err error) {
 	for _, method := range methods {
-		if !checkMethod(method) {
+		if !r.checkMethod(method) {
 			msgErro := fmt.Sprintf("Method %s on %s not allowed",
-				method, r.Path)
+				method, path)
 			err = errors.New(msgErro)
 		}
 	}
-	if err == nil {
-		if len(r.Params) > 0 {
-			http.HandleFunc(r.Path,
-				setRouteParams(gateMethod(r.Handler, methods...), r.Params))
-		} else {
-			http.HandleFunc(r.Path, gateMethod(r.Handler,
-				methods...))
-
-		}
-	}
 	return
 }
 
 // Internal method that validates whether the value passed in methods matches
-// existing values.
-func checkMethod(m string) bool {
+// existing values, either standard or extended on the Router.
+func (r *Router) checkMethod(m string) bool {
 	for _, method := range methods {
 		if m == method {
 			return true
 		}
 	}
+	for _, method := range r.extensions {
+		if m == method {
+			return true
+		}
+	}
 	return false
 }
 
-// ----------------------------------------------------------------------------
-// Router middlewares
-// ----------------------------------------------------------------------------
+// Internal method that reports the settings made on the Routers the path is
+// registered through, other than the base Router, which never read them.
+// Each Router is reported once.
+func (r *Router) checkSettings(path string) {
+	root := r.base()
+	for scope := r; scope != root; scope = scope.parent {
+		if scope.reported {
+			continue
+		}
 
-// Ensures that routing is done using valid methods
-func gateMethod(next http.HandlerFunc, methods ...string) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		for _, method := range methods {
-			if r.Method == method {
-				next.ServeHTTP(w, r)
-				return
+		var settings []string
+		for name, set := range map[string]bool{
+			"HandleOPTIONS":    scope.HandleOPTIONS,
+			"HandleHEAD":       scope.HandleHEAD,
+			"TrailingSlash":    scope.TrailingSlash != PathStrict,
+			"CleanPath":        scope.CleanPath != PathStrict,
+			"LetterCase":       scope.LetterCase != PathStrict,
+			"NotFound":         scope.NotFound != nil,
+			"MethodNotAllowed": scope.MethodNotAllowed != nil,
+		} {
+			if set {
+				settings = append(settings, name)
 			}
 		}
+		if len(settings) == 0 {
+			continue
+		}
+
+		sort.Strings(settings)
+		scope.reported = true
+		root.errs = append(root.errs, fmt.Errorf("Route %s is registered "+
+			"through a Router setting %s, which only the Router returned by "+
+			"NewRouter reads", path, strings.Join(settings, ", ")))
+	}
+}
 
-		w.WriteHeader(http.StatusNotFound)
-		w.Write([]byte(`{"error": "The method for this route doesnt exist"}`))
+// Internal method that returns the Router holding the routes registered
+// through r, which is r itself unless it was returned by another Router, as
+// done by Host, Route or the matcher methods.
+func (r *Router) base() *Router {
+	for r.parent != nil {
+		r = r.parent
+	}
+	return r
+}
 
+// Internal method that validates whether the value is a valid HTTP token, as
+// required for method names.
+func validToken(value string) bool {
+	if value == "" {
+		return false
 	}
+	for _, c := range value {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case strings.ContainsRune("!#$%&'*+-.^_`|~", c):
+		default:
+			return false
+		}
+	}
+	return true
 }
 
-//	Method to obtain route params in a built route
-func getBuiltRouteParams(path string) (string, [][]string) {
-	rgx := regexp.MustCompile(`(?m){(\w*)}`)
-	rgxStart := regexp.MustCompile(`(?m)(^\/)`)
-	rgxEnd := regexp.MustCompile(`(?m)(\/$)`)
-	return rgxEnd.ReplaceAllString(rgxStart.ReplaceAllString(
-		rgx.Split(path, -1)[0], ""), ""), rgx.FindAllStringSubmatch(path, -1)
+// Error joins the messages of all registration errors.
+func (errs registrationErrors) Error() string {
+	messages := make([]string, len(errs))
+	for idx, err := range errs {
+		messages[idx] = err.Error()
+	}
+	return strings.Join(messages, "; ")
 }
 
-// Method to obtain request methods
-func getRequestParams(path string) (*BuiltRoute, map[int]string) {
-	router := getRouter()
+// ----------------------------------------------------------------------------
+// Router middlewares
+// ----------------------------------------------------------------------------
 
-	var builtRouteList *BuiltRoute
-	params := make(map[int]string)
+// Method to obtain route params in a built route, along with the static text
+// that precedes them.
+func getBuiltRouteParams(tokens []token) (string, []string) {
+	var (
+		key   string
+		names []string
+	)
 
-	for _, route := range router.built {
-		rgx := regexp.MustCompile(route.KeyRoute)
-		if rgx.FindString(path) != "" {
-			if (len(strings.Split(
-				rgx.Split(path, -1)[1], "/")) - 1) == len(route.Var) {
-				builtRouteList = route
-				for idx, val := range strings.Split(rgx.Split(path, -1)[1],
-					"/") {
-					if idx != 0 {
-						params[idx-1] = val
-					}
-				}
-			}
+	for idx, tk := range tokens {
+		if tk.param {
+			names = append(names, tk.text)
+		} else if idx == 0 {
+			key = strings.TrimSuffix(strings.TrimPrefix(tk.text, "/"), "/")
 		}
 	}
-	return builtRouteList, params
+	return key, names
 }
 
 // RouteVariables used to capture and store parameters passed to built routes
@@ -391,29 +1094,23 @@ func RouteVariables(r *http.Request) *ParamReceiver {
 	return &receiver
 }
 
-// Defines and organizes route parameters by applying them in request
-func setRouteParams(next http.HandlerFunc, params []Variable) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		ctx := r.Context()
-
-		for _, param := range params {
-			name := key(param.Name)
-			ctx = context.WithValue(ctx, name, param.Value)
-		}
-
-		r = r.WithContext(ctx)
-
-		next.ServeHTTP(w, r)
-	}
-}
-
 // ----------------------------------------------------------------------------
 // ParamReceiver middlewares
 // ----------------------------------------------------------------------------
 
 // GetVar return a value of router variable
 func (pr *ParamReceiver) GetVar(variable string) interface{} {
-	return pr.request.Context().Value(key(variable))
+	current, ok := pr.request.Context().Value(routeKey{}).(*routeContext)
+	if !ok {
+		return nil
+	}
+
+	for idx := len(current.params) - 1; idx >= 0; idx-- {
+		if current.params[idx].Name == variable {
+			return current.params[idx].Value
+		}
+	}
+	return nil
 }
 
 // ----------------------------------------------------------------------------
@@ -426,3 +1123,92 @@ func healthApplication(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte(`{"alive": "Server running"}`))
 }
+
+// Function used as default answer to requests whose path does not exist.
+func notFound(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusNotFound)
+	w.Write([]byte(`{"msg": "route not found"}`))
+}
+
+// Function used as default answer to requests whose path exists for other
+// methods only.
+func methodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusMethodNotAllowed)
+	w.Write([]byte(`{"error": "The method for this route doesnt exist"}`))
+}
+
+// Response writer used to serve HEAD requests through GET handlers, keeping
+// status and headers while discarding the body.
+type headResponseWriter struct {
+	http.ResponseWriter
+}
+
+// Write discards the body, reporting it as fully written.
+func (w headResponseWriter) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+// Function used to redirect the request to the canonical form of its path,
+// keeping the query string. Methods other than GET and HEAD are redirected
+// with 308 so clients repeat them with the same body.
+func redirectPath(w http.ResponseWriter, r *http.Request, path string) {
+	target := *r.URL
+	target.Path = path
+	target.RawPath = ""
+
+	code := http.StatusMovedPermanently
+	if r.Method != "GET" && r.Method != "HEAD" {
+		code = http.StatusPermanentRedirect
+	}
+	http.Redirect(w, r, target.String(), code)
+}
+
+// Function that returns a copy of the request to a mounted route, with the
+// prefix the route is mounted on stripped from its path. The path below the
+// prefix is the value of the catch-all parameter, missing when the request
+// path is the prefix itself.
+func stripPrefix(req *http.Request, route *Route, m *match) *http.Request {
+	rest := ""
+	if len(m.values) == len(route.built.Var) {
+		rest = m.values[len(m.values)-1]
+	}
+
+	stripped := new(http.Request)
+	*stripped = *req
+	stripped.URL = new(url.URL)
+	*stripped.URL = *req.URL
+	stripped.URL.Path = "/" + rest
+	stripped.URL.RawPath = ""
+
+	raw := req.URL.RawPath
+	for idx := len(raw) - 1; idx >= 0; idx-- {
+		if raw[idx] != '/' {
+			continue
+		}
+		if value, err := url.PathUnescape(raw[idx+1:]); err == nil &&
+			value == rest {
+			stripped.URL.RawPath = raw[idx:]
+			break
+		}
+	}
+	return stripped
+}
+
+// Function that returns the canonical form of a path, without repeated
+// slashes or "." and ".." segments, keeping its trailing slash.
+func cleanPath(p string) string {
+	if p == "" {
+		return "/"
+	}
+	if p[0] != '/' {
+		p = "/" + p
+	}
+
+	clean := pathpkg.Clean(p)
+	if p[len(p)-1] == '/' && clean != "/" {
+		clean += "/"
+	}
+	return clean
+}
@@ -1,10 +1,16 @@
 package bellt
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestNewRouter(t *testing.T) {
@@ -15,12 +21,37 @@ func TestNewRouter(t *testing.T) {
 
 }
 
-func TestGetRouter(t *testing.T) {
+func TestIndependentRouters(t *testing.T) {
 	router := NewRouter()
-	sameRouter := getRouter()
-	if router != sameRouter {
+	otherRouter := NewRouter()
+	if router == otherRouter {
 		t.Error("Router with colapses!")
 	}
+
+	router.HandleFunc("/admin", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("admin"))
+	}, "GET")
+
+	req, err := http.NewRequest("GET", "/admin", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	otherRouter.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusNotFound)
+	}
+
+	rr = httptest.NewRecorder()
+	http.DefaultServeMux.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusNotFound {
+		t.Errorf("default mux returned wrong status code: got %v want %v",
+			status, http.StatusNotFound)
+	}
 }
 
 func TestBuiltRoute(t *testing.T) {
@@ -37,9 +68,7 @@ func TestBuiltRoute(t *testing.T) {
 		w.Write([]byte(id))
 	}, "GET")
 	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(redirectBuiltRoute)
-
-	handler.ServeHTTP(rr, req)
+	router.ServeHTTP(rr, req)
 
 	if status := rr.Code; status != http.StatusOK {
 		t.Errorf("handler returned wrong status code: got %v want %v",
@@ -53,6 +82,103 @@ func TestBuiltRoute(t *testing.T) {
 	}
 }
 
+func TestBuiltRouteDoesNotGrow(t *testing.T) {
+	router := NewRouter()
+
+	router.HandleFunc("/user/{id}", func(w http.ResponseWriter, r *http.Request) {
+		rv := RouteVariables(r)
+		w.Write([]byte(fmt.Sprintf("%v", rv.GetVar("id"))))
+	}, "GET")
+	registered := len(router.routes)
+
+	for _, id := range []string{"1", "2", "2", "3"} {
+		req, err := http.NewRequest("GET", "/user/"+id, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		if rr.Body.String() != id {
+			t.Errorf("handler returned unexpected body: got %v want %v",
+				rr.Body.String(), id)
+		}
+	}
+
+	if len(router.routes) != registered {
+		t.Errorf("serving built routes created routes: got %v want %v",
+			len(router.routes), registered)
+	}
+
+	if router.tree.children[0].children != nil {
+		t.Errorf("serving built routes changed the route tree")
+	}
+}
+
+func TestConcurrentBuiltRoute(t *testing.T) {
+	router := NewRouter()
+
+	router.HandleFunc("/user/{id}", func(w http.ResponseWriter, r *http.Request) {
+		rv := RouteVariables(r)
+		w.Write([]byte(fmt.Sprintf("%v", rv.GetVar("id"))))
+	}, "GET")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 500; i++ {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+
+			req := httptest.NewRequest("GET", "/user/"+id, nil)
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			if rr.Body.String() != id {
+				t.Errorf("handler returned unexpected body: got %v want %v",
+					rr.Body.String(), id)
+			}
+		}(fmt.Sprint(i))
+	}
+	wg.Wait()
+}
+
+func TestConcurrentRegistration(t *testing.T) {
+	router := NewRouter()
+
+	router.HandleFunc("/user/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("user"))
+	}, "GET")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 200; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			router.HandleFunc(fmt.Sprintf("/item%d/{id}", i),
+				func(w http.ResponseWriter, r *http.Request) {}, "GET")
+		}(i)
+		go func() {
+			defer wg.Done()
+
+			req := httptest.NewRequest("GET", "/user/1", nil)
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			if rr.Body.String() != "user" {
+				t.Errorf("handler returned unexpected body: got %v want %v",
+					rr.Body.String(), "user")
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(router.built) != 201 {
+		t.Errorf("wrong number of built routes: got %v want %v",
+			len(router.built), 201)
+	}
+}
+
 func TestNotFound(t *testing.T) {
 	router := NewRouter()
 
@@ -67,9 +193,7 @@ func TestNotFound(t *testing.T) {
 		w.Write([]byte(id))
 	}, "GET")
 	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(redirectBuiltRoute)
-
-	handler.ServeHTTP(rr, req)
+	router.ServeHTTP(rr, req)
 
 	if status := rr.Code; status != http.StatusNotFound {
 		t.Errorf("handler returned wrong status code: got %v want %v",
@@ -92,6 +216,482 @@ func TestMethod(t *testing.T) {
 		w.Write([]byte(id))
 	}, "RET")
 
+	want := "Method RET on /user not allowed"
+	if err := router.Err(); err == nil || err.Error() != want {
+		t.Errorf("wrong registration error: got %v want %v", err, want)
+	}
+}
+
+func TestRegistrationErrors(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {}
+
+	cases := []struct {
+		path    string
+		methods []string
+		want    string
+	}{
+		{"", []string{"GET"}, "Route path must not be empty"},
+		{"/user/{id", []string{"GET"}, "Route /user/{id has an unclosed parameter"},
+		{"/user/id}", []string{"GET"},
+			"Route /user/id} has a parameter closed without being opened"},
+		{"/user/{}", []string{"GET"}, "Route /user/{} has a malformed parameter {}"},
+		{"/user/{user id}", []string{"GET"},
+			"Route /user/{user id} has a malformed parameter {user id}"},
+		{"/user/{id}/{id}", []string{"GET"},
+			"Route /user/{id}/{id} has the parameter {id} more than once"},
+		{"/user", nil, "Route /user has no methods"},
+		{"/user", []string{"GEt"}, "Method GEt on /user not allowed"},
+	}
+
+	for _, c := range cases {
+		router := NewRouter()
+		router.HandleFunc(c.path, handler, c.methods...)
+
+		if err := router.Err(); err == nil || err.Error() != c.want {
+			t.Errorf("wrong registration error: got %v want %v", err, c.want)
+		}
+	}
+
+	router := NewRouter()
+	router.HandleFunc("/user/{id}", handler, "GET", "POST")
+	router.HandleGroup("/user",
+		router.SubHandleFunc("/{id}", handler, "PUT", "POST"),
+		router.SubHandleFunc("/new", handler, "GEt"),
+	)
+
+	want := "Route POST /user/{id} is already registered; " +
+		"Method GEt on /user/new not allowed"
+	if err := router.Err(); err == nil || err.Error() != want {
+		t.Errorf("wrong registration error: got %v want %v", err, want)
+	}
+
+	router = NewRouter()
+	router.HandleFunc("/user/{id}", handler, "GET")
+	router.HandleFunc("/user/{id}", handler, "POST")
+	if err := router.Err(); err != nil {
+		t.Errorf("unexpected registration error: %v", err)
+	}
+}
+
+func TestRouteConflicts(t *testing.T) {
+	router := NewRouter()
+
+	router.HandleFunc("/user/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("id"))
+	}, "GET")
+	router.HandleFunc("/user/{name}", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("name"))
+	}, "GET", "PUT")
+	router.HandleFunc("/user/{name}", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("name"))
+	}, "PUT")
+	router.HandleFunc("/user/new", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("new"))
+	}, "GET")
+	router.HandleFunc("/user/{id}/posts", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("posts"))
+	}, "GET")
+	router.HandleGroup("/user",
+		router.SubHandleFunc("/{userID}/posts", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("group"))
+		}, "GET"),
+	)
+
+	want := "Route GET /user/{name} conflicts with GET /user/{id}; " +
+		"Route GET /user/{userID}/posts conflicts with GET /user/{id}/posts"
+	if err := router.Err(); err == nil || err.Error() != want {
+		t.Errorf("wrong registration error: got %v want %v", err, want)
+	}
+
+	cases := []struct {
+		method, path, body string
+	}{
+		{"GET", "/user/1", "id"},
+		{"PUT", "/user/1", "name"},
+		{"GET", "/user/new", "new"},
+		{"GET", "/user/1/posts", "posts"},
+	}
+
+	for _, c := range cases {
+		serveCase(t, router, c.method, c.path, http.StatusOK, c.body)
+	}
+}
+
+// Handler writing the name of the route followed by its variables, used to
+// check which route served a request.
+func namedHandler(name string, variables ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rv := RouteVariables(r)
+		body := name
+		for _, variable := range variables {
+			body += fmt.Sprintf(" %v", rv.GetVar(variable))
+		}
+		w.Write([]byte(body))
+	}
+}
+
+// Serve a request with the method and path through the router, checking its
+// status code and, when one is expected, its body. The response is returned
+// for further checks.
+func serveCase(t *testing.T, router http.Handler, method, path string,
+	status int, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	return serveRequest(t, router, httptest.NewRequest(method, path, nil),
+		status, body)
+}
+
+// Serve the request through the router, checking the response as done by
+// serveCase, for requests carrying a host or headers.
+func serveRequest(t *testing.T, router http.Handler, req *http.Request,
+	status int, body string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	if rr.Code != status {
+		t.Errorf("%s %s%s: handler returned wrong status code: got %v want %v",
+			req.Method, req.Host, req.URL.RequestURI(), rr.Code, status)
+	}
+	if body != "" && rr.Body.String() != body {
+		t.Errorf("%s %s%s: handler returned unexpected body: got %v want %v",
+			req.Method, req.Host, req.URL.RequestURI(), rr.Body.String(), body)
+	}
+	return rr
+}
+
+func TestConstrainedParams(t *testing.T) {
+	router := NewRouter()
+
+	err := router.RegisterConstraint("hex", regexp.MustCompile(
+		`^[0-9a-f]+$`).MatchString)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router.HandleFunc("/orders/{id:int}", namedHandler("int", "id"), "GET")
+	router.HandleFunc("/users/{id:uuid}", namedHandler("uuid", "id"), "GET")
+	router.HandleFunc("/posts/{slug:[a-z0-9-]+}", namedHandler("slug", "slug"),
+		"GET")
+	router.HandleFunc("/colors/{color:hex}", namedHandler("hex", "color"),
+		"GET")
+	router.HandleFunc("/items/{id:int}", namedHandler("int", "id"), "GET")
+	router.HandleFunc("/items/{name}", namedHandler("plain", "name"), "GET")
+	router.HandleFunc("/codes/{code:[A-Z]{3}}", namedHandler("code", "code"),
+		"GET")
+
+	if err := router.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/orders/42", http.StatusOK, "int 42"},
+		{"/orders/abc", http.StatusNotFound, `{"msg": "route not found"}`},
+		{"/users/6ba7b810-9dad-11d1-80b4-00c04fd430c8", http.StatusOK,
+			"uuid 6ba7b810-9dad-11d1-80b4-00c04fd430c8"},
+		{"/users/42", http.StatusNotFound, `{"msg": "route not found"}`},
+		{"/posts/hello-world-2", http.StatusOK, "slug hello-world-2"},
+		{"/posts/Hello", http.StatusNotFound, `{"msg": "route not found"}`},
+		{"/colors/ff00aa", http.StatusOK, "hex ff00aa"},
+		{"/colors/red", http.StatusNotFound, `{"msg": "route not found"}`},
+		{"/items/7", http.StatusOK, "int 7"},
+		{"/items/seven", http.StatusOK, "plain seven"},
+		{"/codes/BRL", http.StatusOK, "code BRL"},
+		{"/codes/BR", http.StatusNotFound, `{"msg": "route not found"}`},
+	}
+
+	for _, c := range cases {
+		serveCase(t, router, "GET", c.path, c.status, c.body)
+	}
+}
+
+func TestConstraintErrors(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {}
+
+	cases := []struct {
+		path string
+		want string
+	}{
+		{"/orders/{id:}", "Route /orders/{id:} has an empty constraint on " +
+			"parameter {id}"},
+		{"/orders/{id:number}", "Route /orders/{id:number} uses the unknown " +
+			"constraint number"},
+		{"/orders/{id:[0-9}", "Route /orders/{id:[0-9} has an invalid " +
+			"constraint [0-9: "},
+	}
+
+	for _, c := range cases {
+		router := NewRouter()
+		router.HandleFunc(c.path, handler, "GET")
+
+		if err := router.Err(); err == nil ||
+			!strings.HasPrefix(err.Error(), c.want) {
+			t.Errorf("wrong registration error: got %v want %v", err, c.want)
+		}
+	}
+
+	router := NewRouter()
+	if err := router.RegisterConstraint("not a word", func(string) bool {
+		return true
+	}); err == nil {
+		t.Error("invalid constraint name accepted")
+	}
+}
+
+func TestCatchAllParams(t *testing.T) {
+	router := NewRouter()
+
+	router.HandleFunc("/files/{path...}", namedHandler("files", "path"), "GET")
+	router.HandleFunc("/files/readme", namedHandler("readme"), "GET")
+	router.HandleFunc("/docs/*", namedHandler("docs", "*"), "GET")
+	router.HandleFunc("/repos/{owner}/{path...}",
+		namedHandler("repos", "owner", "path"), "GET")
+
+	if err := router.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/files/a/b/c.txt", http.StatusOK, "files a/b/c.txt"},
+		{"/files/", http.StatusOK, "files "},
+		{"/files/readme", http.StatusOK, "readme"},
+		{"/files/readme/more", http.StatusOK, "files readme/more"},
+		{"/files", http.StatusNotFound, `{"msg": "route not found"}`},
+		{"/docs/guide/install", http.StatusOK, "docs guide/install"},
+		{"/repos/bellt/src/bellt.go", http.StatusOK, "repos bellt src/bellt.go"},
+	}
+
+	for _, c := range cases {
+		serveCase(t, router, "GET", c.path, c.status, c.body)
+	}
+
+	router = NewRouter()
+	router.HandleFunc("/files/{path...}/raw", namedHandler("raw"), "GET")
+	router.HandleFunc("/docs/*", namedHandler("docs"), "GET")
+	router.HandleFunc("/docs/{rest...}", namedHandler("rest"), "GET")
+
+	want := "Route /files/{path...}/raw has a catch-all parameter before its " +
+		"end; Route GET /docs/{rest...} conflicts with GET /docs/*"
+	if err := router.Err(); err == nil || err.Error() != want {
+		t.Errorf("wrong registration error: got %v want %v", err, want)
+	}
+}
+
+func TestInterleavedParams(t *testing.T) {
+	router := NewRouter()
+
+	router.HandleFunc("/users/{id}", namedHandler("user", "id"), "GET")
+	router.HandleFunc("/users/{id}/posts", namedHandler("posts", "id"), "GET")
+	router.HandleFunc("/users/{id}/likes", namedHandler("likes", "id"), "GET")
+	router.HandleFunc("/users/{id}/posts/{postID}",
+		namedHandler("post", "id", "postID"), "GET")
+	router.HandleFunc("/users/{id}/posts/{postID}/comments/{commentID}",
+		namedHandler("comment", "id", "postID", "commentID"), "GET")
+	router.HandleGroup("/orgs/{org}",
+		router.SubHandleFunc("/teams/{team}/members",
+			namedHandler("members", "org", "team"), "GET"),
+	)
+
+	if err := router.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/users/7", http.StatusOK, "user 7"},
+		{"/users/7/posts", http.StatusOK, "posts 7"},
+		{"/users/7/likes", http.StatusOK, "likes 7"},
+		{"/users/7/posts/3", http.StatusOK, "post 7 3"},
+		{"/users/7/posts/3/comments/1", http.StatusOK, "comment 7 3 1"},
+		{"/orgs/go/teams/core/members", http.StatusOK, "members go core"},
+		{"/users/7/shares", http.StatusNotFound, `{"msg": "route not found"}`},
+		{"/users/7/posts/3/comments", http.StatusNotFound,
+			`{"msg": "route not found"}`},
+	}
+
+	for _, c := range cases {
+		serveCase(t, router, "GET", c.path, c.status, c.body)
+	}
+}
+
+func TestPartialSegmentParams(t *testing.T) {
+	router := NewRouter()
+
+	router.HandleFunc("/files/{name}.{ext}", namedHandler("file", "name", "ext"),
+		"GET")
+	router.HandleFunc("/v{version:int}/items", namedHandler("items", "version"),
+		"GET")
+	router.HandleFunc("/videos", namedHandler("videos"), "GET")
+	router.HandleFunc("/releases/v{major}.{minor}-{tag}",
+		namedHandler("release", "major", "minor", "tag"), "GET")
+	router.HandleFunc("/reports/{id}", namedHandler("report", "id"), "GET")
+	router.HandleFunc("/reports/{id}.json", namedHandler("json", "id"), "GET")
+	router.HandleFunc("/avatars/user-{id}/{size}",
+		namedHandler("avatar", "id", "size"), "GET")
+
+	if err := router.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/files/photo.png", http.StatusOK, "file photo png"},
+		{"/files/archive.tar.gz", http.StatusOK, "file archive tar.gz"},
+		{"/files/noext", http.StatusNotFound, `{"msg": "route not found"}`},
+		{"/files/.png", http.StatusNotFound, `{"msg": "route not found"}`},
+		{"/v2/items", http.StatusOK, "items 2"},
+		{"/vx/items", http.StatusNotFound, `{"msg": "route not found"}`},
+		{"/videos", http.StatusOK, "videos"},
+		{"/releases/v1.12-beta", http.StatusOK, "release 1 12 beta"},
+		{"/reports/42", http.StatusOK, "report 42"},
+		{"/reports/42.json", http.StatusOK, "json 42"},
+		{"/reports/42.xml", http.StatusOK, "report 42.xml"},
+		{"/avatars/user-7/large", http.StatusOK, "avatar 7 large"},
+	}
+
+	for _, c := range cases {
+		serveCase(t, router, "GET", c.path, c.status, c.body)
+	}
+
+	router = NewRouter()
+	router.HandleFunc("/files/{name}{ext}", namedHandler("file"), "GET")
+
+	want := "Route /files/{name}{ext} has the parameters {name} and {ext} " +
+		"without a literal between them"
+	if err := router.Err(); err == nil || err.Error() != want {
+		t.Errorf("wrong registration error: got %v want %v", err, want)
+	}
+}
+
+// All orderings of the values, used to register route tables in every order.
+func permutations(values []string) [][]string {
+	if len(values) <= 1 {
+		return [][]string{values}
+	}
+
+	var result [][]string
+	for idx := range values {
+		rest := append(append([]string(nil), values[:idx]...), values[idx+1:]...)
+		for _, perm := range permutations(rest) {
+			result = append(result, append([]string{values[idx]}, perm...))
+		}
+	}
+	return result
+}
+
+func TestMatchPrecedence(t *testing.T) {
+	patterns := []string{
+		"/items/new",
+		"/items/{id:int}",
+		"/items/{hex:[0-9a-f]+}",
+		"/items/{name}",
+		"/items/{rest...}",
+		"/items/{name}/detail",
+	}
+
+	cases := []struct {
+		path, body string
+	}{
+		{"/items/new", "/items/new"},
+		{"/items/42", "/items/{id:int}"},
+		{"/items/beef", "/items/{hex:[0-9a-f]+}"},
+		{"/items/tea", "/items/{name}"},
+		{"/items/new/detail", "/items/{name}/detail"},
+		{"/items/42/detail", "/items/{name}/detail"},
+		{"/items/new/other", "/items/{rest...}"},
+		{"/items/", "/items/{rest...}"},
+	}
+
+	for _, order := range permutations(patterns) {
+		router := NewRouter()
+		for _, pattern := range order {
+			router.HandleFunc(pattern, namedHandler(pattern), "GET")
+		}
+
+		if err := router.Err(); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, c := range cases {
+			req := httptest.NewRequest("GET", c.path, nil)
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			if rr.Body.String() != c.body {
+				t.Errorf("%s registered as %v: got %v want %v", c.path, order,
+					rr.Body.String(), c.body)
+			}
+		}
+	}
+}
+
+func TestStandardMethods(t *testing.T) {
+	router := NewRouter()
+
+	for _, method := range []string{"PATCH", "HEAD", "OPTIONS", "CONNECT",
+		"TRACE"} {
+		router.HandleFunc("/"+method, func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(r.Method))
+		}, method)
+		router.HandleFunc("/"+method+"/{id}", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(r.Method))
+		}, method)
+
+		for _, path := range []string{"/" + method, "/" + method + "/1"} {
+			serveCase(t, router, method, path, http.StatusOK, "")
+		}
+	}
+}
+
+func TestExtendMethods(t *testing.T) {
+	router := NewRouter()
+
+	router.HandleFunc("/files", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("before"))
+	}, "PROPFIND")
+
+	if err := router.ExtendMethods("PROPFIND", "PURGE"); err != nil {
+		t.Fatal(err)
+	}
+
+	router.HandleFunc("/files", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("files"))
+	}, "PROPFIND")
+	router.HandleGroup("/cache",
+		router.SubHandleFunc("/{key}", func(w http.ResponseWriter, r *http.Request) {
+			rv := RouteVariables(r)
+			w.Write([]byte(fmt.Sprintf("%v", rv.GetVar("key"))))
+		}, "PURGE"),
+	)
+
+	cases := []struct {
+		method, path, body string
+	}{
+		{"PROPFIND", "/files", "files"},
+		{"PURGE", "/cache/home", "home"},
+	}
+
+	for _, c := range cases {
+		serveCase(t, router, c.method, c.path, http.StatusOK, c.body)
+	}
+
+	if err := router.ExtendMethods("BAD METHOD"); err == nil {
+		t.Error("invalid extension method accepted")
+	}
 }
 
 func TestRoute(t *testing.T) {
@@ -108,11 +708,7 @@ func TestRoute(t *testing.T) {
 
 	rr := httptest.NewRecorder()
 
-	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Write([]byte("works"))
-	})
-
-	handler.ServeHTTP(rr, req)
+	router.ServeHTTP(rr, req)
 
 	if status := rr.Code; status != http.StatusOK {
 		t.Errorf("handler returned wrong status code: got %v want %v",
@@ -134,6 +730,34 @@ func oneMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
+// Middleware recording its name in the X-Middleware header, to check the
+// order in which middlewares run.
+func traceMiddleware(name string) Middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("X-Middleware", name)
+			next.ServeHTTP(w, r)
+		}
+	}
+}
+
+// Middleware in the standard form, recording its name as traceMiddleware.
+func standardMiddleware(name string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("X-Middleware", name)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Middleware types declared by other packages with the accepted signatures,
+// as done by other routers and middleware chaining libraries.
+type (
+	muxMiddleware    func(http.Handler) http.Handler
+	chainConstructor func(http.HandlerFunc) http.HandlerFunc
+)
+
 func TestMiddleware(t *testing.T) {
 	router := NewRouter()
 
@@ -151,9 +775,7 @@ func TestMiddleware(t *testing.T) {
 		oneMiddleware,
 	), "GET")
 	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(redirectBuiltRoute)
-
-	handler.ServeHTTP(rr, req)
+	router.ServeHTTP(rr, req)
 
 	if status := rr.Code; status != http.StatusOK {
 		t.Errorf("handler returned wrong status code: got %v want %v",
@@ -183,9 +805,7 @@ func TestGroupRoute(t *testing.T) {
 	)
 
 	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(redirectBuiltRoute)
-
-	handler.ServeHTTP(rr, req)
+	router.ServeHTTP(rr, req)
 
 	if status := rr.Code; status != http.StatusOK {
 		t.Errorf("handler returned wrong status code: got %v want %v",
@@ -213,13 +833,21 @@ func TestMethodNotAllowed(t *testing.T) {
 		w.Write([]byte(id))
 	}, "POST")
 	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(redirectBuiltRoute)
-
-	handler.ServeHTTP(rr, req)
+	router.ServeHTTP(rr, req)
 
-	if status := rr.Code; status != http.StatusNotFound {
+	if status := rr.Code; status != http.StatusMethodNotAllowed {
 		t.Errorf("handler returned wrong status code: got %v want %v",
-			status, http.StatusOK)
+			status, http.StatusMethodNotAllowed)
+	}
+
+	if allow := rr.Header().Get("Allow"); allow != "POST" {
+		t.Errorf("handler returned wrong Allow header: got %v want %v",
+			allow, "POST")
+	}
+
+	if contentType := rr.Header().Get("Content-Type"); contentType != "application/json" {
+		t.Errorf("handler returned wrong Content-Type: got %v want %v",
+			contentType, "application/json")
 	}
 
 	expected := `{"error": "The method for this route doesnt exist"}`
@@ -229,26 +857,1258 @@ func TestMethodNotAllowed(t *testing.T) {
 	}
 }
 
-func TestHealthModel(t *testing.T) {
+func TestMethodNotAllowedAllowHeader(t *testing.T) {
+	router := NewRouter()
 
-	req, err := http.NewRequest("GET", "/health", nil)
-	if err != nil {
-		t.Fatal(err)
+	router.HandleFunc("/items", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("list"))
+	}, "GET")
+	router.HandleFunc("/items", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("create"))
+	}, "POST")
+
+	cases := []struct {
+		method, path string
+		status       int
+		allow, body  string
+	}{
+		{"GET", "/items", http.StatusOK, "", "list"},
+		{"POST", "/items", http.StatusOK, "", "create"},
+		{"PUT", "/items", http.StatusMethodNotAllowed, "GET, POST",
+			`{"error": "The method for this route doesnt exist"}`},
+		{"PUT", "/item", http.StatusNotFound, "", `{"msg": "route not found"}`},
 	}
 
-	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(healthApplication)
+	for _, c := range cases {
+		rr := serveCase(t, router, c.method, c.path, c.status, c.body)
+		if allow := rr.Header().Get("Allow"); allow != c.allow {
+			t.Errorf("%s %s: handler returned wrong Allow header: got %v "+
+				"want %v", c.method, c.path, allow, c.allow)
+		}
+	}
+}
 
-	handler.ServeHTTP(rr, req)
+func TestAutomaticOptionsAndHead(t *testing.T) {
+	router := NewRouter()
 
-	if status := rr.Code; status != http.StatusOK {
+	router.HandleFunc("/items/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Item", fmt.Sprintf("%v", RouteVariables(r).GetVar("id")))
+		w.Write([]byte("item"))
+	}, "GET")
+	router.HandleFunc("/items/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("update"))
+	}, "PUT")
+
+	req := httptest.NewRequest("OPTIONS", "/items/1", nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusMethodNotAllowed {
 		t.Errorf("handler returned wrong status code: got %v want %v",
-			status, http.StatusOK)
+			status, http.StatusMethodNotAllowed)
 	}
 
-	expected := `{"alive": "Server running"}`
-	if rr.Body.String() != expected {
-		t.Errorf("handler returned unexpected body: got %v want %v",
-			rr.Body.String(), expected)
+	router.HandleOPTIONS = true
+	router.HandleHEAD = true
+
+	cases := []struct {
+		method      string
+		status      int
+		allow, body string
+	}{
+		{"OPTIONS", http.StatusNoContent, "GET, HEAD, OPTIONS, PUT", ""},
+		{"HEAD", http.StatusOK, "", ""},
+		{"DELETE", http.StatusMethodNotAllowed, "GET, HEAD, OPTIONS, PUT",
+			`{"error": "The method for this route doesnt exist"}`},
+	}
+
+	for _, c := range cases {
+		rr := serveCase(t, router, c.method, "/items/1", c.status, c.body)
+		if allow := rr.Header().Get("Allow"); allow != c.allow {
+			t.Errorf("%s: handler returned wrong Allow header: got %v want %v",
+				c.method, allow, c.allow)
+		}
+		if c.body == "" && rr.Body.Len() > 0 {
+			t.Errorf("%s: handler returned a body: %v", c.method,
+				rr.Body.String())
+		}
 	}
+
+	req = httptest.NewRequest("HEAD", "/items/7", nil)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	if item := rr.Header().Get("X-Item"); item != "7" {
+		t.Errorf("HEAD lost the GET headers: got %v want %v", item, "7")
+	}
+}
+
+func TestCustomErrorHandlers(t *testing.T) {
+	router := NewRouter()
+
+	router.NotFound = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("<h1>Not Found</h1>"))
+	})
+	router.MethodNotAllowed = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write([]byte(`{"code": 405}`))
+	})
+
+	router.HandleFunc("/items", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("list"))
+	}, "GET")
+
+	cases := []struct {
+		method, path string
+		status       int
+		contentType  string
+		allow, body  string
+	}{
+		{"GET", "/missing", http.StatusNotFound, "text/html", "",
+			"<h1>Not Found</h1>"},
+		{"POST", "/items", http.StatusMethodNotAllowed, "application/json",
+			"GET", `{"code": 405}`},
+	}
+
+	for _, c := range cases {
+		rr := serveCase(t, router, c.method, c.path, c.status, c.body)
+		if contentType := rr.Header().Get("Content-Type"); contentType != c.contentType {
+			t.Errorf("%s %s: handler returned wrong Content-Type: got %v "+
+				"want %v", c.method, c.path, contentType, c.contentType)
+		}
+		if allow := rr.Header().Get("Allow"); allow != c.allow {
+			t.Errorf("%s %s: handler returned wrong Allow header: got %v "+
+				"want %v", c.method, c.path, allow, c.allow)
+		}
+	}
+}
+
+func TestPathPolicies(t *testing.T) {
+	newRouter := func(trailingSlash, cleanPath PathPolicy) *Router {
+		router := NewRouter()
+		router.TrailingSlash = trailingSlash
+		router.CleanPath = cleanPath
+		router.HandleFunc("/user/{id}", namedHandler("user", "id"), "GET", "POST")
+		router.HandleFunc("/docs/", namedHandler("docs"), "GET")
+		return router
+	}
+
+	cases := []struct {
+		trailingSlash, cleanPath PathPolicy
+		method, path             string
+		status                   int
+		location, body           string
+	}{
+		{PathStrict, PathStrict, "GET", "/user/123/", http.StatusNotFound, "",
+			`{"msg": "route not found"}`},
+		{PathStrict, PathStrict, "GET", "//user/123", http.StatusNotFound, "",
+			`{"msg": "route not found"}`},
+		{PathRedirect, PathStrict, "GET", "/user/123/?tab=1",
+			http.StatusMovedPermanently, "/user/123?tab=1", ""},
+		{PathRedirect, PathStrict, "POST", "/user/123/",
+			http.StatusPermanentRedirect, "/user/123", ""},
+		{PathRedirect, PathStrict, "GET", "/docs", http.StatusMovedPermanently,
+			"/docs/", ""},
+		{PathRedirect, PathStrict, "GET", "/missing/", http.StatusNotFound, "",
+			`{"msg": "route not found"}`},
+		{PathEquivalent, PathStrict, "GET", "/user/123/", http.StatusOK, "",
+			"user 123"},
+		{PathEquivalent, PathStrict, "GET", "/docs", http.StatusOK, "", "docs"},
+		{PathStrict, PathRedirect, "GET", "//user/./123",
+			http.StatusMovedPermanently, "/user/123", ""},
+		{PathStrict, PathRedirect, "GET", "/docs/../user/123",
+			http.StatusMovedPermanently, "/user/123", ""},
+		{PathStrict, PathEquivalent, "GET", "/user//x/../123", http.StatusOK, "",
+			"user 123"},
+		{PathStrict, PathEquivalent, "GET", "/user/123/.", http.StatusOK, "",
+			"user 123"},
+		{PathStrict, PathEquivalent, "GET", "/user/123//", http.StatusNotFound,
+			"", `{"msg": "route not found"}`},
+		{PathRedirect, PathRedirect, "GET", "//user/123/",
+			http.StatusMovedPermanently, "/user/123", ""},
+		{PathEquivalent, PathEquivalent, "GET", "//docs", http.StatusOK, "",
+			"docs"},
+	}
+
+	for _, c := range cases {
+		router := newRouter(c.trailingSlash, c.cleanPath)
+
+		rr := serveCase(t, router, c.method, c.path, c.status, c.body)
+		if location := rr.Header().Get("Location"); location != c.location {
+			t.Errorf("%s: handler returned wrong location: got %v want %v",
+				c.path, location, c.location)
+		}
+	}
+}
+
+func TestLetterCasePolicy(t *testing.T) {
+	newRouter := func(letterCase, trailingSlash PathPolicy) *Router {
+		router := NewRouter()
+		router.LetterCase = letterCase
+		router.TrailingSlash = trailingSlash
+		router.HandleFunc("/users/{id}", namedHandler("user", "id"), "GET")
+		router.HandleFunc("/users/{id}/Posts", namedHandler("posts", "id"), "GET")
+		router.HandleFunc("/files/{name}.PDF", namedHandler("pdf", "name"), "GET")
+		router.HandleFunc("/v{version}Beta", namedHandler("beta", "version"), "GET")
+		return router
+	}
+
+	cases := []struct {
+		letterCase, trailingSlash PathPolicy
+		path                      string
+		status                    int
+		location, body            string
+	}{
+		{PathStrict, PathStrict, "/Users/42", http.StatusNotFound, "",
+			`{"msg": "route not found"}`},
+		{PathEquivalent, PathStrict, "/Users/AbC", http.StatusOK, "", "user AbC"},
+		{PathEquivalent, PathStrict, "/USERS/AbC/posts", http.StatusOK, "",
+			"posts AbC"},
+		{PathEquivalent, PathStrict, "/files/Report.pdf", http.StatusOK, "",
+			"pdf Report"},
+		{PathEquivalent, PathStrict, "/V2beta", http.StatusOK, "", "beta 2"},
+		{PathRedirect, PathStrict, "/Users/AbC/POSTS?page=2",
+			http.StatusMovedPermanently, "/users/AbC/Posts?page=2", ""},
+		{PathRedirect, PathStrict, "/users/42", http.StatusOK, "", "user 42"},
+		{PathRedirect, PathStrict, "/Customers/42", http.StatusNotFound, "",
+			`{"msg": "route not found"}`},
+		{PathEquivalent, PathRedirect, "/Users/42/", http.StatusMovedPermanently,
+			"/users/42", ""},
+		{PathEquivalent, PathEquivalent, "/Users/42/", http.StatusOK, "",
+			"user 42"},
+	}
+
+	for _, c := range cases {
+		router := newRouter(c.letterCase, c.trailingSlash)
+
+		rr := serveCase(t, router, "GET", c.path, c.status, c.body)
+		if location := rr.Header().Get("Location"); location != c.location {
+			t.Errorf("%s: handler returned wrong location: got %v want %v",
+				c.path, location, c.location)
+		}
+	}
+
+	router := newRouter(PathRedirect, PathStrict)
+	router.HandleFunc("/Docs/{path...}", namedHandler("docs", "path"), "GET")
+	router.HandleFunc("/users/new", namedHandler("new user"), "GET")
+
+	for _, c := range []struct{ method, path string }{
+		{"PUT", "/docs/A/B"},
+		{"POST", "/Users/NEW"},
+	} {
+		rr := serveCase(t, router, c.method, c.path,
+			http.StatusMethodNotAllowed, "")
+		if location := rr.Header().Get("Location"); location != "" {
+			t.Errorf("%s %s: handler redirected to %v", c.method, c.path,
+				location)
+		}
+		if allow := rr.Header().Get("Allow"); allow != "GET" {
+			t.Errorf("%s %s: wrong Allow header: got %v want GET", c.method,
+				c.path, allow)
+		}
+	}
+}
+
+func TestHostRouting(t *testing.T) {
+	router := NewRouter()
+
+	api := router.Host("api.example.com")
+	api.HandleGroup("/v1",
+		api.SubHandleFunc("/users", namedHandler("api users"), "GET"),
+		api.SubHandleFunc("/users/{id}", namedHandler("api user", "id"), "GET"),
+	)
+	admin := router.Host("admin.example.com")
+	admin.HandleGroup("/v1",
+		admin.SubHandleFunc("/users", namedHandler("admin users"), "GET", "POST"),
+	)
+	tenant := router.Host("{tenant:[a-z]+}.example.com")
+	tenant.HandleFunc("/users/{id}", namedHandler("tenant user", "tenant", "id"),
+		"GET")
+	router.Host("{region}-{env}.example.com:8080").HandleFunc("/status",
+		namedHandler("status", "region", "env"), "GET")
+	router.HandleFunc("/users/{id}", namedHandler("user", "id"), "GET")
+
+	if err := router.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		method, host, path string
+		status             int
+		body               string
+	}{
+		{"GET", "api.example.com", "/v1/users", http.StatusOK, "api users"},
+		{"GET", "API.Example.com:443", "/v1/users/42", http.StatusOK,
+			"api user 42"},
+		{"GET", "admin.example.com", "/v1/users", http.StatusOK, "admin users"},
+		{"POST", "admin.example.com", "/v1/users", http.StatusOK, "admin users"},
+		{"POST", "api.example.com", "/v1/users", http.StatusMethodNotAllowed, ""},
+		{"GET", "www.example.org", "/v1/users", http.StatusNotFound, ""},
+		{"GET", "acme.example.com", "/users/42", http.StatusOK,
+			"tenant user acme 42"},
+		{"GET", "acme.example.com.", "/users/42", http.StatusOK,
+			"tenant user acme 42"},
+		{"GET", "acme2.example.com", "/users/42", http.StatusOK, "user 42"},
+		{"GET", "www.example.org", "/users/42", http.StatusOK, "user 42"},
+		{"GET", "eu-prod.example.com:8080", "/status", http.StatusOK,
+			"status eu prod"},
+		{"GET", "eu-prod.example.com", "/status", http.StatusNotFound, ""},
+	}
+
+	for _, c := range cases {
+		req := httptest.NewRequest(c.method, c.path, nil)
+		req.Host = c.host
+		serveRequest(t, router, req, c.status, c.body)
+	}
+}
+
+func TestHostPrecedence(t *testing.T) {
+	hosts := []string{
+		"{tenant}.example.com",
+		"{tenant:[a-z]+}.example.com",
+		"api.example.com",
+		"",
+	}
+
+	cases := []struct {
+		host, want string
+	}{
+		{"api.example.com", "api.example.com"},
+		{"acme.example.com", "{tenant:[a-z]+}.example.com"},
+		{"acme2.example.com", "{tenant}.example.com"},
+		{"www.example.org", ""},
+	}
+
+	for _, order := range permutations(hosts) {
+		router := NewRouter()
+		for _, host := range order {
+			router.Host(host).HandleFunc("/users", namedHandler(host), "GET")
+		}
+		if err := router.Err(); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, c := range cases {
+			req := httptest.NewRequest("GET", "/users", nil)
+			req.Host = c.host
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			if rr.Body.String() != c.want {
+				t.Errorf("%v %s: wrong route: got %v want %v", order, c.host,
+					rr.Body.String(), c.want)
+			}
+		}
+	}
+}
+
+func TestHostRegistrationErrors(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {}
+
+	router := NewRouter()
+	router.Host("api.example.com").HandleFunc("/users", handler, "GET")
+	router.HandleFunc("/users", handler, "GET")
+	router.Host("API.example.com").HandleFunc("/users", handler, "POST")
+	router.Host("API.Example.com").HandleFunc("/users", handler, "GET")
+	router.Host("api.example.com").HandleFunc("/users", handler, "GET")
+	router.Host("{tenant}.example.com").HandleFunc("/u", handler, "GET")
+	router.Host("{org:int}.example.com").HandleFunc("/u", handler, "GET")
+	router.Host("{org}.Example.com").HandleFunc("/u", handler, "GET")
+	router.Host("{tenant}.example.com").HandleFunc("/{tenant}", handler, "GET")
+	router.Host("{tenant.example.com").HandleFunc("/users", handler, "GET")
+	router.Host("{tenant:number}.example.com").HandleFunc("/users", handler,
+		"GET")
+	router.Host("{rest...}").HandleFunc("/users", handler, "GET")
+
+	want := "Route GET API.Example.com/users is already registered; " +
+		"Route GET api.example.com/users is already registered; " +
+		"Route GET {org}.Example.com/u conflicts with GET " +
+		"{tenant}.example.com/u; " +
+		"Route /{tenant} has the parameter {tenant} of host " +
+		"{tenant}.example.com; " +
+		"Host {tenant.example.com has an unclosed parameter; " +
+		"Host {tenant:number}.example.com uses the unknown constraint number; " +
+		"Host {rest...} has the catch-all parameter {rest}"
+	if err := router.Err(); err == nil || err.Error() != want {
+		t.Errorf("wrong registration error: got %v want %v", err, want)
+	}
+}
+
+func TestRouteMatchers(t *testing.T) {
+	router := NewRouter()
+
+	router.Headers("Accept-Version", "2").HandleFunc("/items",
+		namedHandler("items v2"), "GET")
+	router.Queries("format", "csv").HandleFunc("/items",
+		namedHandler("items csv"), "GET")
+	router.HandleFunc("/items", namedHandler("items"), "GET")
+	router.Schemes("HTTPS").HandleFunc("/login", namedHandler("login"), "GET",
+		"POST")
+	router.Queries("draft", "").HandleFunc("/posts/{id}",
+		namedHandler("draft", "id"), "PUT")
+	router.MatcherFunc(func(r *http.Request) bool {
+		return r.Header.Get("X-Beta") != ""
+	}).Headers("Accept-Version", "2").HandleFunc("/items",
+		namedHandler("items beta v2"), "GET")
+
+	if err := router.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		method, target string
+		header         http.Header
+		status         int
+		body           string
+	}{
+		{"GET", "/items", nil, http.StatusOK, "items"},
+		{"GET", "/items", http.Header{"Accept-Version": {"2"}}, http.StatusOK,
+			"items v2"},
+		{"GET", "/items", http.Header{"Accept-Version": {"3"}}, http.StatusOK,
+			"items"},
+		{"GET", "/items?format=csv", nil, http.StatusOK, "items csv"},
+		{"GET", "/items", http.Header{"Accept-Version": {"2"},
+			"X-Beta": {"1"}}, http.StatusOK, "items beta v2"},
+		{"GET", "https://example.com/login", nil, http.StatusOK, "login"},
+		{"GET", "http://example.com/login", nil, http.StatusNotFound, ""},
+		{"DELETE", "https://example.com/login", nil,
+			http.StatusMethodNotAllowed, ""},
+		{"PUT", "/posts/7?draft", nil, http.StatusOK, "draft 7"},
+		{"PUT", "/posts/7", nil, http.StatusNotFound, ""},
+	}
+
+	for _, c := range cases {
+		req := httptest.NewRequest(c.method, c.target, nil)
+		for name, values := range c.header {
+			req.Header[name] = values
+		}
+		serveRequest(t, router, req, c.status, c.body)
+	}
+}
+
+func TestMatcherRegistrationErrors(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {}
+	beta := func(r *http.Request) bool { return true }
+
+	router := NewRouter()
+	router.Headers("Accept-Version", "2").HandleFunc("/items", handler, "GET")
+	router.Headers("accept-version", "2").HandleFunc("/items", handler, "GET")
+	router.Headers("Accept-Version", "3").HandleFunc("/items", handler, "GET")
+	router.MatcherFunc(beta).HandleFunc("/items", handler, "GET")
+	router.MatcherFunc(beta).HandleFunc("/items", handler, "GET")
+	router.Headers("Accept-Version").HandleFunc("/items", handler, "GET")
+	router.MatcherFunc(nil).HandleFunc("/items", handler, "GET")
+	router.Schemes("http", "https").HandleFunc("/items", handler, "GET")
+	router.Schemes("HTTPS", "http").HandleFunc("/items", handler, "GET")
+
+	want := "Route GET /items is already registered; " +
+		"Headers [Accept-Version] must be given as key and value pairs; " +
+		"Matcher has no function; " +
+		"Route GET /items is already registered"
+	if err := router.Err(); err == nil || err.Error() != want {
+		t.Errorf("wrong registration error: got %v want %v", err, want)
+	}
+}
+
+func TestNamedRoutes(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {}
+
+	router := NewRouter()
+	router.HandleFunc("/user/{id:int}", handler, "GET").Name("user")
+	router.HandleFunc("/files/{path...}", handler, "GET").Name("file")
+	router.HandleFunc("/files/{name}.{ext}", handler, "GET").Name("document")
+	router.HandleGroup("/api/v2",
+		router.SubHandleFunc("/posts/{slug}", handler, "GET").Name("post"),
+	)
+	router.Host("{tenant}.example.com").HandleFunc("/home", handler,
+		"GET").Name("home")
+
+	if err := router.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name   string
+		params []string
+		want   string
+	}{
+		{"user", []string{"id", "42"}, "/user/42"},
+		{"file", []string{"path", "docs/a b/c?.txt"},
+			"/files/docs/a%20b/c%3F.txt"},
+		{"document", []string{"name", "report", "ext", "pdf"},
+			"/files/report.pdf"},
+		{"post", []string{"slug", "hello world"}, "/api/v2/posts/hello%20world"},
+		{"home", []string{"tenant", "acme"}, "//acme.example.com/home"},
+	}
+
+	for _, c := range cases {
+		u, err := router.URL(c.name, c.params...)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", c.name, err)
+			continue
+		}
+		if u.String() != c.want {
+			t.Errorf("%s: wrong url: got %v want %v", c.name, u, c.want)
+		}
+	}
+
+	errorCases := []struct {
+		name   string
+		params []string
+		want   string
+	}{
+		{"missing", nil, "Route name missing is not registered"},
+		{"user", nil, "Route /user/{id:int} requires the parameter {id}"},
+		{"user", []string{"id", "abc"}, "Route /user/{id:int} does not " +
+			`accept "abc" for the parameter {id}`},
+		{"post", []string{"slug", "a/b"}, "Route /api/v2/posts/{slug} does " +
+			`not accept "a/b" for the parameter {slug}`},
+		{"post", []string{"slug", ""}, "Route /api/v2/posts/{slug} does " +
+			`not accept "" for the parameter {slug}`},
+		{"home", []string{"tenant", "a.b"}, "Route {tenant}.example.com/home " +
+			`does not accept "a.b" for the parameter {tenant}`},
+		{"file", []string{"path", "a/b c/../d"}, "Route /files/{path...} " +
+			`does not accept "a/b c/../d" for the parameter {path}`},
+		{"file", []string{"path", "a//b"}, "Route /files/{path...} does " +
+			`not accept "a//b" for the parameter {path}`},
+		{"file", []string{"path", "./a"}, "Route /files/{path...} does " +
+			`not accept "./a" for the parameter {path}`},
+		{"post", []string{"slug", ".."}, "Route /api/v2/posts/{slug} does " +
+			`not accept ".." for the parameter {slug}`},
+		{"document", []string{"name", "a.b", "ext", "pdf"}, "Route " +
+			`/files/{name}.{ext} does not accept "a.b" for the parameter ` +
+			"{name}"},
+		{"user", []string{"id", "1", "page", "2"}, "Route /user/{id:int} " +
+			"has no parameter {page}"},
+		{"user", []string{"id"}, "URL parameters [id] must be given as " +
+			"name and value pairs"},
+	}
+
+	for _, c := range errorCases {
+		if _, err := router.URL(c.name, c.params...); err == nil ||
+			err.Error() != c.want {
+			t.Errorf("%s %v: wrong error: got %v want %v", c.name, c.params,
+				err, c.want)
+		}
+	}
+}
+
+func TestRouteNameErrors(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {}
+
+	router := NewRouter()
+	router.HandleFunc("/users", handler, "GET").Name("users")
+	router.HandleFunc("/people", handler, "GET").Name("users")
+	router.HandleFunc("/members", handler, "GET").Name("")
+	router.HandleFunc("/users", handler, "GET").Name("people")
+
+	want := "Route name users is already used by /users; " +
+		"Route /members name must not be empty; " +
+		"Route GET /users is already registered"
+	if err := router.Err(); err == nil || err.Error() != want {
+		t.Errorf("wrong registration error: got %v want %v", err, want)
+	}
+
+	if _, err := router.URL("people"); err == nil {
+		t.Error("route that failed to register was named")
+	}
+}
+
+func TestRouteMiddlewareAndMeta(t *testing.T) {
+	router := NewRouter()
+
+	router.HandleFunc("/users/{id}", namedHandler("user", "id"), "GET").
+		Use(traceMiddleware("outer"), traceMiddleware("inner"))
+	router.HandleGroup("/admin",
+		router.SubHandleFunc("/stats", namedHandler("stats"), "GET").
+			Use(traceMiddleware("admin")),
+	)
+
+	cases := []struct {
+		path, body string
+		trace      []string
+	}{
+		{"/users/42", "user 42", []string{"outer", "inner"}},
+		{"/admin/stats", "stats", []string{"admin"}},
+	}
+
+	for _, c := range cases {
+		rr := serveCase(t, router, "GET", c.path, http.StatusOK, c.body)
+		if trace := rr.Header()["X-Middleware"]; !reflect.DeepEqual(trace,
+			c.trace) {
+			t.Errorf("%s: wrong middlewares: got %v want %v", c.path, trace,
+				c.trace)
+		}
+	}
+}
+
+func TestRoutesIntrospection(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {}
+
+	router := NewRouter()
+	router.HandleFunc("/users/{id:int}", handler, "GET", "PUT").Name("user").
+		Meta("permission", "users:read").Use(oneMiddleware,
+		traceMiddleware("trace"))
+	router.HandleGroup("/api",
+		router.SubHandleFunc("/check", handler, "GET").Name("check").
+			Meta("public", true),
+	)
+	router.Host("{tenant}.example.com").HandleFunc("/home", handler, "GET")
+	router.HandleFunc("/users/{id:int}", handler, "GET")
+
+	want := []RouteInfo{
+		{
+			Name:       "user",
+			Pattern:    "/users/{id:int}",
+			Methods:    []string{"GET", "PUT"},
+			Middleware: []string{"bellt.oneMiddleware", "bellt.traceMiddleware"},
+			Meta:       map[string]interface{}{"permission": "users:read"},
+		},
+		{
+			Name:    "check",
+			Pattern: "/api/check",
+			Methods: []string{"GET"},
+			Group:   "/api",
+			Meta:    map[string]interface{}{"public": true},
+		},
+		{
+			Host:    "{tenant}.example.com",
+			Pattern: "/home",
+			Methods: []string{"GET"},
+		},
+	}
+
+	if got := router.Routes(); !reflect.DeepEqual(got, want) {
+		t.Errorf("wrong routes: got %+v want %+v", got, want)
+	}
+
+	var visited []string
+	stop := errors.New("stop")
+	err := router.Walk(func(route RouteInfo) error {
+		visited = append(visited, route.Pattern)
+		if route.Group != "" {
+			return stop
+		}
+		return nil
+	})
+	if err != stop {
+		t.Errorf("wrong walk error: got %v want %v", err, stop)
+	}
+	if want := []string{"/users/{id:int}", "/api/check"}; !reflect.DeepEqual(
+		visited, want) {
+		t.Errorf("wrong visited routes: got %v want %v", visited, want)
+	}
+
+	routes := router.Routes()
+	routes[0].Methods[0] = "DELETE"
+	routes[0].Meta["permission"] = "none"
+	if again := router.Routes(); !reflect.DeepEqual(again, want) {
+		t.Errorf("routes changed through their description: got %+v", again)
+	}
+}
+
+func TestNestedRouters(t *testing.T) {
+	router := NewRouter()
+	router.Use(traceMiddleware("root"))
+
+	router.Route("/api", func(api *Router) {
+		api.Use(traceMiddleware("api"))
+		api.HandleFunc("/users/{id}", namedHandler("user", "id"), "GET")
+
+		api.Route("/admin", func(admin *Router) {
+			admin.HandleFunc("/stats", namedHandler("stats"), "GET").
+				Use(traceMiddleware("route"))
+			admin.Use(traceMiddleware("admin"))
+		})
+
+		api.Group(func(beta *Router) {
+			beta.Use(traceMiddleware("beta"))
+			beta.HandleGroup("/beta",
+				beta.SubHandleFunc("/items", namedHandler("beta items"), "GET"),
+			)
+		})
+
+		api.Headers("Accept-Version", "2").Route("/v2", func(v2 *Router) {
+			v2.HandleFunc("/items", namedHandler("items v2"), "GET")
+		})
+
+		api.Route("/v3/", func(v3 *Router) {
+			v3.HandleFunc("/items", namedHandler("items v3"), "GET")
+		})
+	})
+	router.Host("admin.example.com").Route("/", func(admin *Router) {
+		admin.Use(traceMiddleware("host"))
+		admin.HandleFunc("home", namedHandler("admin home"), "GET")
+	})
+	router.HandleFunc("/home", namedHandler("home"), "GET")
+
+	if err := router.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		host, path string
+		header     http.Header
+		status     int
+		body       string
+		trace      []string
+	}{
+		{"", "/api/users/42", nil, http.StatusOK, "user 42",
+			[]string{"root", "api"}},
+		{"", "/api/admin/stats", nil, http.StatusOK, "stats",
+			[]string{"root", "api", "admin", "route"}},
+		{"", "/api/beta/items", nil, http.StatusOK, "beta items",
+			[]string{"root", "api", "beta"}},
+		{"", "/api/v2/items", http.Header{"Accept-Version": {"2"}},
+			http.StatusOK, "items v2", []string{"root", "api"}},
+		{"", "/api/v2/items", nil, http.StatusNotFound, "", nil},
+		{"", "/api/v3/items", nil, http.StatusOK, "items v3",
+			[]string{"root", "api"}},
+		{"admin.example.com", "/home", nil, http.StatusOK, "admin home",
+			[]string{"root", "host"}},
+		{"www.example.com", "/home", nil, http.StatusOK, "home",
+			[]string{"root"}},
+	}
+
+	for _, c := range cases {
+		req := httptest.NewRequest("GET", c.path, nil)
+		if c.host != "" {
+			req.Host = c.host
+		}
+		for name, values := range c.header {
+			req.Header[name] = values
+		}
+		rr := serveRequest(t, router, req, c.status, c.body)
+		if trace := rr.Header()["X-Middleware"]; !reflect.DeepEqual(trace,
+			c.trace) {
+			t.Errorf("%s%s: wrong middlewares: got %v want %v", c.host, c.path,
+				trace, c.trace)
+		}
+	}
+
+	groups := map[string]string{}
+	for _, route := range router.Routes() {
+		groups[route.Pattern] = route.Group
+	}
+	want := map[string]string{
+		"/api/users/{id}":  "/api",
+		"/api/admin/stats": "/api/admin",
+		"/api/beta/items":  "/api/beta",
+		"/api/v2/items":    "/api/v2",
+		"/api/v3/items":    "/api/v3/",
+		"/home":            "",
+	}
+	if !reflect.DeepEqual(groups, want) {
+		t.Errorf("wrong group prefixes: got %v want %v", groups, want)
+	}
+}
+
+func TestScopedRouterSettings(t *testing.T) {
+	router := NewRouter()
+
+	router.Route("/api", func(api *Router) {
+		api.NotFound = namedHandler("api not found")
+		api.HandleOPTIONS = true
+		api.TrailingSlash = PathRedirect
+		if err := api.ExtendMethods("PURGE"); err != nil {
+			t.Fatal(err)
+		}
+		if err := api.RegisterConstraint("hex", regexp.MustCompile(
+			`^[0-9a-f]+$`).MatchString); err != nil {
+			t.Fatal(err)
+		}
+		api.HandleFunc("/users", namedHandler("users"), "GET")
+		api.HandleFunc("/posts", namedHandler("posts"), "GET")
+	})
+	router.Host("admin.example.com").Group(func(admin *Router) {
+		admin.Group(func(inner *Router) {
+			inner.MethodNotAllowed = namedHandler("inner not allowed")
+			inner.HandleFunc("/stats", namedHandler("stats"), "GET")
+		})
+	})
+	router.HandleFunc("/cache/{key:hex}", namedHandler("cache", "key"),
+		"PURGE")
+
+	want := "Route /api/users is registered through a Router setting " +
+		"HandleOPTIONS, NotFound, TrailingSlash, which only the Router " +
+		"returned by NewRouter reads; " +
+		"Route /stats is registered through a Router setting " +
+		"MethodNotAllowed, which only the Router returned by NewRouter reads"
+	if err := router.Err(); err == nil || err.Error() != want {
+		t.Errorf("wrong registration error: got %v want %v", err, want)
+	}
+
+	cases := []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/cache/ff", http.StatusOK, "cache ff"},
+		{"/cache/zz", http.StatusNotFound, ""},
+	}
+
+	for _, c := range cases {
+		serveCase(t, router, "PURGE", c.path, c.status, c.body)
+	}
+}
+
+func TestMiddlewareComposition(t *testing.T) {
+	router := NewRouter()
+	calls := 0
+	router.Use(func(next http.Handler) http.Handler {
+		calls++
+		router.Routes()
+		return next
+	})
+	router.Pre(func(next http.Handler) http.Handler {
+		router.Routes()
+		return next
+	})
+
+	paths := []string{"/a", "/b", "/c"}
+	for _, path := range paths {
+		router.HandleFunc(path, namedHandler(path), "GET")
+	}
+	for idx := 0; idx < 10; idx++ {
+		router.Use(traceMiddleware("late"))
+	}
+	if calls != 0 {
+		t.Errorf("middlewares built before serving: got %v calls", calls)
+	}
+
+	serve := func() {
+		for _, path := range paths {
+			for idx := 0; idx < 2; idx++ {
+				rr := httptest.NewRecorder()
+				router.ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+				if rr.Body.String() != path {
+					t.Errorf("%s: handler returned unexpected body: got %v "+
+						"want %v", path, rr.Body.String(), path)
+				}
+			}
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		serve()
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("building the middlewares deadlocked")
+	}
+	if calls != len(paths) {
+		t.Errorf("wrong middleware constructor calls: got %v want %v", calls,
+			len(paths))
+	}
+
+	router.Use(traceMiddleware("later"))
+	serve()
+	if calls != 2*len(paths) {
+		t.Errorf("wrong middleware constructor calls after Use: got %v want %v",
+			calls, 2*len(paths))
+	}
+}
+
+func TestRouterMiddleware(t *testing.T) {
+	router := NewRouter()
+
+	router.HandleFunc("/users/{id}", namedHandler("user", "id"), "GET")
+	router.Pre(traceMiddleware("pre"), func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if _, ok := CurrentRoute(r); ok {
+				t.Error("route known before matching")
+			}
+			r.URL.Path = strings.TrimPrefix(r.URL.Path, "/legacy")
+			next.ServeHTTP(w, r)
+		}
+	})
+	router.Use(traceMiddleware("use"), func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			route, ok := CurrentRoute(r)
+			if !ok {
+				t.Error("route unknown after matching")
+			}
+			w.Header().Set("X-Route", route.Pattern)
+			w.Header().Set("X-Id", fmt.Sprint(RouteVariables(r).GetVar("id")))
+			next.ServeHTTP(w, r)
+		}
+	})
+	router.HandleFunc("/health/{check}", namedHandler("check", "check"), "GET")
+
+	cases := []struct {
+		method, path string
+		status       int
+		trace        []string
+		route, id    string
+	}{
+		{"GET", "/users/42", http.StatusOK, []string{"pre", "use"},
+			"/users/{id}", "42"},
+		{"GET", "/legacy/users/7", http.StatusOK, []string{"pre", "use"},
+			"/users/{id}", "7"},
+		{"GET", "/health/db", http.StatusOK, []string{"pre", "use"},
+			"/health/{check}", "<nil>"},
+		{"GET", "/missing", http.StatusNotFound, []string{"pre"}, "", ""},
+		{"POST", "/users/42", http.StatusMethodNotAllowed, []string{"pre"}, "",
+			""},
+	}
+
+	for _, c := range cases {
+		rr := serveCase(t, router, c.method, c.path, c.status, "")
+		if trace := rr.Header()["X-Middleware"]; !reflect.DeepEqual(trace,
+			c.trace) {
+			t.Errorf("%s: wrong middlewares: got %v want %v", c.path, trace,
+				c.trace)
+		}
+		if route := rr.Header().Get("X-Route"); route != c.route {
+			t.Errorf("%s: wrong route: got %v want %v", c.path, route, c.route)
+		}
+		if id := rr.Header().Get("X-Id"); id != c.id {
+			t.Errorf("%s: wrong variable: got %v want %v", c.path, id, c.id)
+		}
+	}
+}
+
+func TestHandleAndMount(t *testing.T) {
+	echoPath := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Method + " " + r.URL.EscapedPath()))
+	})
+
+	v2 := NewRouter()
+	v2.HandleFunc("/users/{id}", namedHandler("v2 user", "id"), "GET")
+
+	router := NewRouter()
+	router.Handle("/ping", echoPath, "GET")
+	router.Mount("/static", echoPath)
+	router.HandleFunc("/static/special", namedHandler("special"), "GET")
+	router.Mount("/v2/", v2)
+	router.Mount("/tenants/{tenant}", namedHandler("tenant", "tenant", "*"))
+	router.Route("/debug", func(debug *Router) {
+		debug.Use(traceMiddleware("debug"))
+		debug.Mount("/pprof", echoPath)
+	})
+
+	if err := router.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		method, path string
+		status       int
+		body         string
+		trace        []string
+	}{
+		{"GET", "/ping", http.StatusOK, "GET /ping", nil},
+		{"POST", "/ping", http.StatusMethodNotAllowed, "", nil},
+		{"GET", "/static/css/site.css", http.StatusOK, "GET /css/site.css", nil},
+		{"DELETE", "/static/cache", http.StatusOK, "DELETE /cache", nil},
+		{"GET", "/static", http.StatusOK, "GET /", nil},
+		{"GET", "/static/", http.StatusOK, "GET /", nil},
+		{"GET", "/static/a%2Fb/c", http.StatusOK, "GET /a%2Fb/c", nil},
+		{"GET", "/static/special", http.StatusOK, "special", nil},
+		{"GET", "/v2/users/42", http.StatusOK, "v2 user 42", nil},
+		{"GET", "/v2/missing", http.StatusNotFound, "", nil},
+		{"GET", "/tenants/acme/files/a.txt", http.StatusOK,
+			"tenant acme files/a.txt", nil},
+		{"GET", "/debug/pprof/heap", http.StatusOK, "GET /heap",
+			[]string{"debug"}},
+		{"GET", "/staticfiles", http.StatusNotFound, "", nil},
+	}
+
+	for _, c := range cases {
+		rr := serveCase(t, router, c.method, c.path, c.status, c.body)
+		if trace := rr.Header()["X-Middleware"]; !reflect.DeepEqual(trace,
+			c.trace) {
+			t.Errorf("%s %s: wrong middlewares: got %v want %v", c.method,
+				c.path, trace, c.trace)
+		}
+	}
+}
+
+func TestMountRegistrationErrors(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	router := NewRouter()
+	router.Mount("/static", handler)
+	router.Mount("/static/", handler)
+	router.HandleFunc("/static", handler, "GET")
+	router.Handle("/ping", nil, "GET")
+	router.Mount("/files/{path...}", handler)
+
+	want := "Route /static/* conflicts with /static/*; " +
+		"Route /static conflicts with /static/*; " +
+		"Route /ping has no handler; " +
+		"Route /files/{path...}/* has a catch-all parameter before its end"
+	if err := router.Err(); err == nil || err.Error() != want {
+		t.Errorf("wrong registration error: got %v want %v", err, want)
+	}
+}
+
+func TestStandardMiddleware(t *testing.T) {
+	router := NewRouter()
+	router.Pre(standardMiddleware("pre"), traceMiddleware("pre2"))
+	router.Use(traceMiddleware("router"), standardMiddleware("router2"))
+
+	router.HandleFunc("/chain", Use(namedHandler("chain"),
+		standardMiddleware("use"), traceMiddleware("use2")), "GET")
+	router.HandleFunc("/users/{id}", namedHandler("user", "id"), "GET").
+		Use(standardMiddleware("route"), oneMiddleware)
+	router.Route("/api", func(api *Router) {
+		api.Use(standardMiddleware("group"))
+		api.HandleGroup("/v1",
+			api.SubHandleFunc("/items", namedHandler("items"), "GET").
+				Use(Middleware(traceMiddleware("sub"))),
+		)
+	})
+	router.HandleFunc("/named", Use(namedHandler("named"),
+		muxMiddleware(standardMiddleware("mux")),
+		chainConstructor(traceMiddleware("chain"))), "GET").
+		Use(muxMiddleware(standardMiddleware("route")))
+
+	if err := router.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		path, body string
+		trace      []string
+	}{
+		{"/chain", "chain", []string{"pre", "pre2", "router", "router2", "use",
+			"use2"}},
+		{"/users/42", "user 42", []string{"pre", "pre2", "router", "router2",
+			"route"}},
+		{"/api/v1/items", "items", []string{"pre", "pre2", "router",
+			"router2", "group", "sub"}},
+		{"/named", "named", []string{"pre", "pre2", "router", "router2",
+			"route", "mux", "chain"}},
+	}
+
+	for _, c := range cases {
+		rr := serveCase(t, router, "GET", c.path, http.StatusOK, c.body)
+		if trace := rr.Header()["X-Middleware"]; !reflect.DeepEqual(trace,
+			c.trace) {
+			t.Errorf("%s: wrong middlewares: got %v want %v", c.path, trace,
+				c.trace)
+		}
+	}
+
+	want := []string{"bellt.traceMiddleware", "bellt.standardMiddleware",
+		"bellt.standardMiddleware", "bellt.oneMiddleware"}
+	if got := router.Routes()[1].Middleware; !reflect.DeepEqual(got, want) {
+		t.Errorf("wrong middleware names: got %v want %v", got, want)
+	}
+}
+
+func TestMiddlewareErrors(t *testing.T) {
+	var (
+		nilMiddleware func(http.Handler) http.Handler
+		nilNamed      muxMiddleware
+	)
+
+	router := NewRouter()
+	router.Use("logger")
+	router.Pre(nil)
+	router.HandleFunc("/users", namedHandler("users"), "GET").
+		Use(nilMiddleware)
+	router.Use(nilNamed)
+	router.Use(func(next http.Handler) http.HandlerFunc { return nil })
+
+	want := "Middleware of type string is not supported; " +
+		"Middleware of type <nil> is not supported; " +
+		"Route /users: Middleware must not be nil; " +
+		"Middleware must not be nil; " +
+		"Middleware of type func(http.Handler) http.HandlerFunc is not " +
+		"supported"
+	if err := router.Err(); err == nil || err.Error() != want {
+		t.Errorf("wrong registration error: got %v want %v", err, want)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Use accepted an unsupported middleware")
+		}
+	}()
+	Use(namedHandler("users"), func(w http.ResponseWriter, r *http.Request) {})
+}
+
+func TestHealthModel(t *testing.T) {
+
+	req, err := http.NewRequest("GET", "/health", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(healthApplication)
+
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+
+	expected := `{"alive": "Server running"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+}
+
+func TestHealthRoute(t *testing.T) {
+	router := NewRouter()
+	router.HandleHealth()
+	router.Route("/api", func(api *Router) {
+		api.HandleHealth()
+	})
+
+	cases := []struct {
+		router       *Router
+		method, path string
+		status       int
+		body         string
+	}{
+		{router, "GET", "/health", http.StatusOK, `{"alive": "Server running"}`},
+		{router, "GET", "/api/health", http.StatusOK,
+			`{"alive": "Server running"}`},
+		{router, "POST", "/health", http.StatusMethodNotAllowed, ""},
+		{NewRouter(), "GET", "/health", http.StatusNotFound, ""},
+	}
+
+	for _, c := range cases {
+		serveCase(t, c.router, c.method, c.path, c.status, c.body)
+	}
+
+	var patterns []string
+	for _, route := range router.Routes() {
+		patterns = append(patterns, route.Pattern)
+	}
+	if want := []string{"/health", "/api/health"}; !reflect.DeepEqual(
+		patterns, want) {
+		t.Errorf("wrong routes: got %v want %v", patterns, want)
+	}
+}
+
+// Route table shaped like a service with ~800 routes, used by the benchmarks.
+func benchmarkRoutes() []string {
+	var paths []string
+	for i := 0; i < 200; i++ {
+		paths = append(paths,
+			fmt.Sprintf("/resource%d/items", i),
+			fmt.Sprintf("/resource%d/items/{id}", i),
+			fmt.Sprintf("/resource%d/items/{id}/detail/{detail}", i),
+			fmt.Sprintf("/resource%d/check", i),
+		)
+	}
+	return paths
+}
+
+// Previous per-request regex scan over the built routes, kept as a baseline
+// for the route tree benchmarks.
+func regexScan(built []*BuiltRoute, path string) (*BuiltRoute, map[int]string) {
+	var builtRouteList *BuiltRoute
+	params := make(map[int]string)
+
+	for _, route := range built {
+		rgx := regexp.MustCompile(route.KeyRoute)
+		if rgx.FindString(path) != "" {
+			if (len(strings.Split(
+				rgx.Split(path, -1)[1], "/")) - 1) == len(route.Var) {
+				builtRouteList = route
+				for idx, val := range strings.Split(rgx.Split(path, -1)[1],
+					"/") {
+					if idx != 0 {
+						params[idx-1] = val
+					}
+				}
+			}
+		}
+	}
+	return builtRouteList, params
+}
+
+func BenchmarkRegexScanLookup(b *testing.B) {
+	router := NewRouter()
+	for _, path := range benchmarkRoutes() {
+		router.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {},
+			"GET")
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		regexScan(router.built, "/resource150/items/42")
+	}
+}
+
+func BenchmarkTreeParamLookup(b *testing.B) {
+	router := NewRouter()
+	for _, path := range benchmarkRoutes() {
+		router.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {},
+			"GET")
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m := match{method: "GET"}
+		router.tree.lookup("/resource150/items/42", &m)
+	}
+}
+
+func BenchmarkTreeStaticLookup(b *testing.B) {
+	router := NewRouter()
+	for _, path := range benchmarkRoutes() {
+		router.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {},
+			"GET")
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m := match{method: "GET"}
+		router.tree.lookup("/resource150/check", &m)
+	}
+}
+
+// ResponseWriter discarding the response, so benchmarks only measure the
+// Router.
+type discardWriter struct {
+	header http.Header
+}
+
+func (w *discardWriter) Header() http.Header         { return w.header }
+func (w *discardWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (w *discardWriter) WriteHeader(int)             {}
+
+func benchmarkServeHTTP(b *testing.B, path string) {
+	router := NewRouter()
+	for _, route := range benchmarkRoutes() {
+		router.HandleFunc(route, func(w http.ResponseWriter, r *http.Request) {},
+			"GET")
+	}
+	router.HandleFunc("/users/{id}/posts/{post}",
+		func(w http.ResponseWriter, r *http.Request) {}, "GET")
+
+	w := &discardWriter{header: http.Header{}}
+	req := httptest.NewRequest("GET", path, nil)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		router.ServeHTTP(w, req)
+	}
+}
+
+func BenchmarkServeHTTPStatic(b *testing.B) {
+	benchmarkServeHTTP(b, "/resource150/check")
+}
+
+func BenchmarkServeHTTPParams(b *testing.B) {
+	benchmarkServeHTTP(b, "/users/42/posts/7")
 }
@@ -0,0 +1,170 @@
+// Copyright 2019 Guilherme Caruso. All rights reserved.
+// Use of this source code is governed by a MIT License
+// license that can be found in the LICENSE file.
+
+package bellt
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Route returns a Router that registers its routes on r under the prefix,
+// after calling fn with it to declare them. The returned Router inherits the
+// prefix, host, matchers and middlewares of r, and may be nested or extended
+// with its own:
+//
+//	router.Route("/api", func(api *bellt.Router) {
+//		api.Use(authMiddleware)
+//		api.HandleFunc("/users", listUsers, "GET")
+//
+//		api.Route("/admin", func(admin *bellt.Router) {
+//			admin.Use(adminMiddleware)
+//			admin.HandleFunc("/stats", showStats, "GET")
+//		})
+//	})
+func (r *Router) Route(prefix string, fn func(r *Router)) *Router {
+	sub := &Router{parent: r, prefix: prefix}
+	if fn != nil {
+		fn(sub)
+	}
+	return sub
+}
+
+// Group returns a Router that registers its routes on r, after calling fn
+// with it to declare them, as done by Route without a prefix. It is used to
+// give middlewares to part of the routes of r.
+func (r *Router) Group(fn func(r *Router)) *Router {
+	return r.Route("", fn)
+}
+
+// Use adds middlewares to every route registered through the Router, its
+// groups included, whether the routes are registered before or after. The
+// middlewares of outer Routers run first, followed by the ones of inner
+// Routers and the ones added to the route itself.
+//
+// These middlewares run once a route matches the request, so they see its
+// variables through RouteVariables and the route through CurrentRoute, but
+// never run for requests answered by the Router itself, such as 404, 405 or
+// redirects. Middlewares that must see every request are added by Pre.
+//
+// Middlewares are accepted in the forms taken by bellt.Use, and the ones of
+// other types are reported by Err. They wrap the handler of each route when
+// it serves its first request after middlewares are added, not on every call
+// to Use.
+func (r *Router) Use(middleware ...interface{}) {
+	root := r.base()
+	root.mu.Lock()
+	defer root.mu.Unlock()
+
+	layers, err := toLayers(middleware)
+	if err != nil {
+		root.errs = append(root.errs, err)
+		return
+	}
+	r.middleware = append(r.middleware, layers...)
+	root.version++
+}
+
+// Pre adds middlewares that run for every request served by the Router before
+// its route is matched, including the requests no route matches. They may
+// change the request, such as its path or method, before it is matched, but
+// no route nor variables are known yet. Pre middlewares always belong to the
+// Router serving requests, even when added through a Router returned by
+// Route, Group, Host or the matcher methods.
+// Middlewares are accepted as done by Use.
+func (r *Router) Pre(middleware ...interface{}) {
+	root := r.base()
+	root.mu.Lock()
+	defer root.mu.Unlock()
+
+	layers, err := toLayers(middleware)
+	if err != nil {
+		root.errs = append(root.errs, err)
+		return
+	}
+	root.pre = append(root.pre, layers...)
+	root.version++
+}
+
+// Internal method that returns the prefix given to the Router and to the
+// Routers it was derived from.
+func (r *Router) scopePrefix() string {
+	prefix := ""
+	for ; r != nil; r = r.parent {
+		prefix = joinPath(r.prefix, prefix)
+	}
+	return prefix
+}
+
+// Function that appends the path to the prefix with exactly one slash between
+// them, returning either of them unchanged when the other one is empty.
+func joinPath(prefix, path string) string {
+	if prefix == "" {
+		return path
+	}
+	if path == "" {
+		return prefix
+	}
+	return strings.TrimSuffix(prefix, "/") + "/" + strings.TrimPrefix(path, "/")
+}
+
+// Internal method that returns the middlewares of the route, the ones of the
+// Routers it was registered through first, outermost first.
+func (route *Route) middleware() []layer {
+	var chain []layer
+	for scope := route.scope; scope != nil; scope = scope.parent {
+		chain = append(append([]layer(nil), scope.middleware...), chain...)
+	}
+	return append(chain, route.use...)
+}
+
+// Internal method that wraps the handler of the route with its middlewares,
+// keeping the result until middlewares are added to the Router again. The
+// middlewares are called without holding the lock of the Router, so they may
+// use it, and only once for every change of the middlewares.
+func (r *Router) compose(route *Route) http.HandlerFunc {
+	route.build.Lock()
+	defer route.build.Unlock()
+
+	r.mu.RLock()
+	handler, version := route.handler, r.version
+	fresh := handler != nil && route.version == version
+	layers := route.middleware()
+	r.mu.RUnlock()
+	if fresh {
+		return handler
+	}
+
+	handler = cascade(route.Handler, layers)
+	r.mu.Lock()
+	if r.version == version {
+		route.handler, route.version = handler, version
+	}
+	r.mu.Unlock()
+	return handler
+}
+
+// Internal method that wraps serve with the middlewares added by Pre, as done
+// by compose for the routes.
+func (r *Router) composePre() http.HandlerFunc {
+	r.preBuild.Lock()
+	defer r.preBuild.Unlock()
+
+	r.mu.RLock()
+	handler, version := r.preHandler, r.version
+	fresh := handler != nil && r.preVersion == version
+	layers := append([]layer(nil), r.pre...)
+	r.mu.RUnlock()
+	if fresh {
+		return handler
+	}
+
+	handler = cascade(r.serve, layers)
+	r.mu.Lock()
+	if r.version == version {
+		r.preHandler, r.preVersion = handler, version
+	}
+	r.mu.Unlock()
+	return handler
+}
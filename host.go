@@ -0,0 +1,185 @@
+// Copyright 2019 Guilherme Caruso. All rights reserved.
+// Use of this source code is governed by a MIT License
+// license that can be found in the LICENSE file.
+
+package bellt
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// Ranks of host patterns, in the order they are tried.
+const (
+	literalHost = iota
+	constrainedHost
+	paramHost
+	anyHost
+)
+
+// hostPattern is the compiled form of a host pattern such as
+// "{tenant}.example.com", matching request hosts case-insensitively. The port
+// of the request host is only compared when the pattern declares one. Rank
+// and literal, the length of its static text, tell how specific it is, and
+// shape is the pattern in lower case without the names of its parameters, the
+// same for the patterns matching the same hosts.
+type hostPattern struct {
+	text    string
+	shape   string
+	tokens  []token
+	names   []string
+	checks  []func(string) bool
+	port    bool
+	rank    int
+	literal int
+	rgx     *regexp.Regexp
+}
+
+// Host returns a Router that registers its routes on r, restricted to
+// requests whose host matches the pattern, such as "api.example.com" or
+// "{tenant}.example.com". Parameters take a whole label of the host, or the
+// shortest part of it followed by the literal after them, and accept the same
+// constraints as path parameters. Their values are available through
+// RouteVariables along with the path variables.
+//
+// Among routes with the same path pattern, the ones with a host are tried
+// before the ones answering any host, regardless of registration order: hosts
+// made of literal text only first, then hosts whose parameters are all
+// constrained and then hosts with plain parameters, the ones with more literal
+// text first. The returned Router serves requests through r, so it only
+// scopes registration, groups included:
+//
+//	api := router.Host("api.example.com")
+//	api.HandleGroup("/v1",
+//		api.SubHandleFunc("/users", listUsers, "GET"),
+//	)
+func (r *Router) Host(pattern string) *Router {
+	return &Router{parent: r, host: pattern}
+}
+
+// Internal method that compiles the host pattern of the Router, the one given
+// to the nearest Host call, returning nil when routes answer any host.
+func (r *Router) hostPattern() (*hostPattern, error) {
+	for ; r != nil; r = r.parent {
+		if r.host != "" {
+			return r.base().compileHost(r.host)
+		}
+	}
+	return nil, nil
+}
+
+// Internal method that compiles a host pattern, resolving the constraints of
+// its parameters.
+func (r *Router) compileHost(pattern string) (*hostPattern, error) {
+	tokens, err := parsePattern("Host", pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	host := &hostPattern{text: pattern, rank: literalHost}
+	var expr, shape strings.Builder
+	expr.WriteString("(?i)^")
+	for idx, tk := range tokens {
+		if !tk.param {
+			host.port = host.port || strings.Contains(tk.text, ":")
+			host.literal += len(tk.text)
+			expr.WriteString(regexp.QuoteMeta(tk.text))
+			shape.WriteString(strings.ToLower(tk.text))
+			continue
+		}
+		if tk.catchAll {
+			return nil, fmt.Errorf("Host %s has the catch-all parameter {%s}",
+				pattern, tk.text)
+		}
+
+		var check func(string) bool
+		if tk.constraint != "" {
+			if check, err = r.compileConstraint("Host", pattern,
+				tk.constraint); err != nil {
+				return nil, err
+			}
+			if host.rank == literalHost {
+				host.rank = constrainedHost
+			}
+		} else {
+			host.rank = paramHost
+		}
+		tokens[idx].check = check
+		host.names = append(host.names, tk.text)
+		host.checks = append(host.checks, check)
+		expr.WriteString(`([^.]+?)`)
+		shape.WriteString("{:" + tk.constraint + "}")
+	}
+	expr.WriteString("$")
+
+	host.shape = shape.String()
+	host.tokens = tokens
+	host.rgx = regexp.MustCompile(expr.String())
+	return host, nil
+}
+
+// Check whether the host matches the pattern, returning the values of its
+// parameters.
+func (h *hostPattern) match(host string) ([]string, bool) {
+	if !h.port {
+		host = stripPort(host)
+	}
+	values := h.rgx.FindStringSubmatch(strings.TrimSuffix(host, "."))
+	if values == nil {
+		return nil, false
+	}
+
+	values = values[1:]
+	for idx, check := range h.checks {
+		if check != nil && !check(values[idx]) {
+			return nil, false
+		}
+	}
+	return values, true
+}
+
+// Internal method that checks whether the route accepts the host of the
+// request, returning the values of the host parameters. Routes without a
+// host pattern accept any request.
+func (route *Route) matchHost(req *http.Request) ([]string, bool) {
+	if route.host == nil {
+		return nil, true
+	}
+	if req == nil {
+		return nil, false
+	}
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	return route.host.match(host)
+}
+
+// Internal method that returns the shape of the host pattern of the route,
+// empty when it answers any host.
+func (route *Route) hostShape() string {
+	if route.host == nil {
+		return ""
+	}
+	return route.host.shape
+}
+
+// Internal method that returns the rank of the host pattern of the route.
+func (route *Route) hostRank() int {
+	if route.host == nil {
+		return anyHost
+	}
+	return route.host.rank
+}
+
+// Function that removes the port from a host, keeping IPv6 addresses whole.
+func stripPort(host string) string {
+	colon := strings.LastIndexByte(host, ':')
+	if colon < 0 || strings.IndexByte(host[colon:], ']') >= 0 {
+		return host
+	}
+	return host[:colon]
+}
@@ -0,0 +1,194 @@
+// Copyright 2019 Guilherme Caruso. All rights reserved.
+// Use of this source code is governed by a MIT License
+// license that can be found in the LICENSE file.
+
+package bellt
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// matcher is a condition a request must satisfy for a route to be selected.
+// Desc identifies the condition when comparing routes; it is empty for
+// functions given to MatcherFunc, which are only equal to themselves.
+type matcher struct {
+	desc  string
+	match func(*http.Request) bool
+}
+
+// Headers returns a Router that registers its routes on r, restricted to
+// requests carrying the headers given as key and value pairs. An empty value
+// only requires the header to be present, otherwise one of its values must be
+// equal to it:
+//
+//	v2 := router.Headers("Accept-Version", "2")
+//	v2.HandleFunc("/items", listItemsV2, "GET")
+//	router.HandleFunc("/items", listItems, "GET")
+func (r *Router) Headers(pairs ...string) *Router {
+	if len(pairs)%2 != 0 {
+		return &Router{parent: r, err: fmt.Errorf("Headers %v must be "+
+			"given as key and value pairs", pairs)}
+	}
+
+	var matchers []*matcher
+	for idx := 0; idx < len(pairs); idx += 2 {
+		name, value := http.CanonicalHeaderKey(pairs[idx]), pairs[idx+1]
+		matchers = append(matchers, &matcher{
+			desc: "header " + name + "=" + value,
+			match: func(req *http.Request) bool {
+				return valueMatches(req.Header[name], value)
+			},
+		})
+	}
+	return &Router{parent: r, matchers: matchers}
+}
+
+// Queries returns a Router that registers its routes on r, restricted to
+// requests whose query string has the keys given as key and value pairs. An
+// empty value only requires the key to be present, otherwise one of its values
+// must be equal to it.
+func (r *Router) Queries(pairs ...string) *Router {
+	if len(pairs)%2 != 0 {
+		return &Router{parent: r, err: fmt.Errorf("Queries %v must be "+
+			"given as key and value pairs", pairs)}
+	}
+
+	var matchers []*matcher
+	for idx := 0; idx < len(pairs); idx += 2 {
+		name, value := pairs[idx], pairs[idx+1]
+		matchers = append(matchers, &matcher{
+			desc: "query " + name + "=" + value,
+			match: func(req *http.Request) bool {
+				return valueMatches(req.URL.Query()[name], value)
+			},
+		})
+	}
+	return &Router{parent: r, matchers: matchers}
+}
+
+// Schemes returns a Router that registers its routes on r, restricted to
+// requests made with one of the schemes, such as "https". The scheme is taken
+// from the request URL when it is absolute, and from the connection otherwise.
+func (r *Router) Schemes(schemes ...string) *Router {
+	lower := make([]string, len(schemes))
+	for idx, scheme := range schemes {
+		lower[idx] = strings.ToLower(scheme)
+	}
+	sort.Strings(lower)
+
+	return &Router{parent: r, matchers: []*matcher{{
+		desc: "scheme " + strings.Join(lower, ","),
+		match: func(req *http.Request) bool {
+			return containsString(lower, requestScheme(req))
+		},
+	}}}
+}
+
+// MatcherFunc returns a Router that registers its routes on r, restricted to
+// the requests accepted by the function. The function runs while the Router
+// looks the route up, holding its lock, so it must not call back into the
+// Router, such as through URL, Routes, CurrentRoute or the registration
+// methods, which may deadlock.
+func (r *Router) MatcherFunc(match func(*http.Request) bool) *Router {
+	if match == nil {
+		return &Router{parent: r, err: errors.New("Matcher has no function")}
+	}
+	return &Router{parent: r, matchers: []*matcher{{match: match}}}
+}
+
+// Internal method that collects the matchers of the Router and of the Routers
+// it was derived from, outermost first, along with an error found while
+// building them.
+func (r *Router) scopeMatchers() ([]*matcher, error) {
+	var (
+		matchers []*matcher
+		err      error
+	)
+	for ; r != nil; r = r.parent {
+		matchers = append(append([]*matcher(nil), r.matchers...), matchers...)
+		if r.err != nil {
+			err = r.err
+		}
+	}
+	return matchers, err
+}
+
+// Internal method that checks whether the route accepts the request, given its
+// host and matchers, returning the values of the host parameters.
+func (route *Route) accept(req *http.Request) ([]string, bool) {
+	values, ok := route.matchHost(req)
+	if !ok {
+		return nil, false
+	}
+	for _, m := range route.matchers {
+		if req == nil || !m.match(req) {
+			return nil, false
+		}
+	}
+	return values, true
+}
+
+// Internal method that checks whether the route must be tried before another
+// one ending on the same tree node. Routes are ranked by how specific their
+// host is, as described by Router.Host, then by the shape of their host, and
+// routes with the same host by their number of matchers, more first.
+func (route *Route) before(other *Route) bool {
+	if a, b := route.hostRank(), other.hostRank(); a != b {
+		return a < b
+	}
+	if route.host != nil {
+		if a, b := route.host.literal, other.host.literal; a != b {
+			return a > b
+		}
+		if a, b := route.host.shape, other.host.shape; a != b {
+			return a < b
+		}
+	}
+	return len(route.matchers) > len(other.matchers)
+}
+
+// Internal function that checks whether both routes have the same matchers,
+// in any order.
+func sameMatchers(a, b []*matcher) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for _, ma := range a {
+		found := false
+		for _, mb := range b {
+			if ma == mb || (ma.desc != "" && ma.desc == mb.desc) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// Function that checks whether the values hold the expected one, or any value
+// when it is empty.
+func valueMatches(values []string, value string) bool {
+	if value == "" {
+		return len(values) > 0
+	}
+	return containsString(values, value)
+}
+
+// Function that returns the scheme of the request in lower case.
+func requestScheme(req *http.Request) string {
+	if req.URL.Scheme != "" {
+		return strings.ToLower(req.URL.Scheme)
+	}
+	if req.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
@@ -0,0 +1,439 @@
+// Copyright 2019 Guilherme Caruso. All rights reserved.
+// Use of this source code is governed by a MIT License
+// license that can be found in the LICENSE file.
+
+package bellt
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// Expression used to validate the name of a parameter.
+var paramName = regexp.MustCompile(`^\w+$`)
+
+// Kinds of node found in the route tree.
+const (
+	staticNode uint8 = iota
+	paramNode
+	catchAllNode
+)
+
+// token is a piece of a route pattern, holding either static text or the
+// name of a parameter, along with the constraint its values must satisfy.
+// Catch-all parameters capture the rest of the path.
+type token struct {
+	text       string
+	param      bool
+	catchAll   bool
+	constraint string
+	check      func(string) bool
+}
+
+// node is a vertex of the compressed prefix tree used to match request paths.
+// Static nodes hold a piece of literal text shared by all routes below them,
+// parameter nodes match a path segment, or part of it when partial, accepted
+// by their check and catch-all nodes match whatever remains of the path.
+type node struct {
+	kind       uint8
+	prefix     string
+	indices    string
+	children   []*node
+	params     []*node
+	catchAll   *node
+	partial    bool
+	constraint string
+	check      func(string) bool
+	routes     []*Route
+}
+
+// match holds the state of a single lookup through the route tree: the
+// request, its method, the values captured along the current branch, the
+// selected route along with the values of its host parameters and the methods
+// allowed by every path that matched. Head is set when a HEAD request is
+// served by a GET route, and fold when static text is compared
+// case-insensitively, building the registered form of the path in canonical.
+type match struct {
+	req        *http.Request
+	method     string
+	values     []string
+	route      *Route
+	hostValues []string
+	allowed    []string
+	head       bool
+	fold       bool
+	canonical  []byte
+}
+
+// Split a route pattern into its static and parameter tokens, checking that
+// every parameter is a well formed "{name}" or "{name:constraint}"
+// placeholder, with a name not used by another parameter of the pattern.
+// A trailing "{name...}" or "/*" declares a catch-all parameter, named "*" in
+// the latter case. Kind names the pattern in error messages, as "Route" or
+// "Host".
+func parsePattern(kind, pattern string) ([]token, error) {
+	var (
+		tokens []token
+		names  []string
+		last   int
+	)
+
+	for idx := 0; idx < len(pattern); idx++ {
+		if len(tokens) > 0 && tokens[len(tokens)-1].catchAll {
+			return nil, fmt.Errorf("%s %s has a catch-all parameter "+
+				"before its end", kind, pattern)
+		}
+
+		switch pattern[idx] {
+		case '*':
+			if idx > 0 && idx == len(pattern)-1 && pattern[idx-1] == '/' {
+				tokens = append(tokens, token{text: pattern[last:idx]},
+					token{text: "*", param: true, catchAll: true})
+				last = idx + 1
+			}
+		case '}':
+			return nil, fmt.Errorf("%s %s has a parameter closed "+
+				"without being opened", kind, pattern)
+		case '{':
+			end := closingBrace(pattern, idx)
+			if end < 0 {
+				return nil, fmt.Errorf("%s %s has an unclosed parameter",
+					kind, pattern)
+			}
+
+			name, constraint := pattern[idx+1:end], ""
+			catchAll := strings.HasSuffix(name, "...")
+			if catchAll {
+				name = strings.TrimSuffix(name, "...")
+			} else if colon := strings.IndexByte(name, ':'); colon >= 0 {
+				name, constraint = name[:colon], name[colon+1:]
+				if constraint == "" {
+					return nil, fmt.Errorf("%s %s has an empty constraint "+
+						"on parameter {%s}", kind, pattern, name)
+				}
+			}
+			if !paramName.MatchString(name) {
+				return nil, fmt.Errorf("%s %s has a malformed parameter "+
+					"%s", kind, pattern, pattern[idx:end+1])
+			}
+			if containsString(names, name) {
+				return nil, fmt.Errorf("%s %s has the parameter {%s} "+
+					"more than once", kind, pattern, name)
+			}
+			names = append(names, name)
+
+			if idx == last && len(tokens) > 0 {
+				return nil, fmt.Errorf("%s %s has the parameters {%s} "+
+					"and {%s} without a literal between them", kind, pattern,
+					tokens[len(tokens)-1].text, name)
+			}
+			if idx > last {
+				tokens = append(tokens, token{text: pattern[last:idx]})
+			}
+			tokens = append(tokens, token{
+				text:       name,
+				param:      true,
+				catchAll:   catchAll,
+				constraint: constraint,
+			})
+			idx = end
+			last = end + 1
+		}
+	}
+
+	if last < len(pattern) && len(tokens) > 0 &&
+		tokens[len(tokens)-1].catchAll {
+		return nil, fmt.Errorf("%s %s has a catch-all parameter "+
+			"before its end", kind, pattern)
+	}
+
+	if last < len(pattern) {
+		tokens = append(tokens, token{text: pattern[last:]})
+	}
+	return tokens, nil
+}
+
+// Position of the brace closing the parameter opened at start, taking braces
+// nested in regular expression constraints into account.
+func closingBrace(pattern string, start int) int {
+	depth := 0
+	for idx := start; idx < len(pattern); idx++ {
+		switch pattern[idx] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return idx
+			}
+		}
+	}
+	return -1
+}
+
+// Insert the tokens of a pattern below the node, returning the node where the
+// pattern ends.
+func (n *node) insert(tokens []token) *node {
+	for _, tk := range tokens {
+		if tk.catchAll {
+			if n.catchAll == nil {
+				n.catchAll = &node{kind: catchAllNode}
+			}
+			n = n.catchAll
+			continue
+		}
+		if tk.param {
+			n = n.addParam(tk)
+			continue
+		}
+		if n.kind == paramNode && tk.text[0] != '/' {
+			n.partial = true
+		}
+		n = n.addStatic(tk.text)
+	}
+	return n
+}
+
+// Add a parameter below the node, reusing the parameter child with the same
+// constraint. Children are kept in the order they are tried when matching:
+// typed constraints, then regular expression constraints, each sorted by
+// their text, and plain parameters last.
+func (n *node) addParam(tk token) *node {
+	for _, child := range n.params {
+		if child.constraint == tk.constraint {
+			return child
+		}
+	}
+
+	child := &node{
+		kind:       paramNode,
+		constraint: tk.constraint,
+		check:      tk.check,
+	}
+
+	idx := 0
+	for idx < len(n.params) &&
+		!constraintBefore(tk.constraint, n.params[idx].constraint) {
+		idx++
+	}
+	n.params = append(n.params, nil)
+	copy(n.params[idx+1:], n.params[idx:])
+	n.params[idx] = child
+	return child
+}
+
+// Check whether a parameter with the first constraint must be tried before a
+// parameter with the second one.
+func constraintBefore(a, b string) bool {
+	if a == "" || b == "" {
+		return b == "" && a != ""
+	}
+	if typedA, typedB := paramName.MatchString(a),
+		paramName.MatchString(b); typedA != typedB {
+		return typedA
+	}
+	return a < b
+}
+
+// Add static text below the node, splitting existing children when they
+// share only part of their prefix with it.
+func (n *node) addStatic(text string) *node {
+	for len(text) > 0 {
+		idx := strings.IndexByte(n.indices, text[0])
+		if idx < 0 {
+			child := &node{kind: staticNode, prefix: text}
+			n.indices += text[:1]
+			n.children = append(n.children, child)
+			return child
+		}
+
+		child := n.children[idx]
+		common := commonPrefix(text, child.prefix)
+		if common < len(child.prefix) {
+			rest := *child
+			rest.prefix = child.prefix[common:]
+			*child = node{
+				kind:     staticNode,
+				prefix:   child.prefix[:common],
+				indices:  rest.prefix[:1],
+				children: []*node{&rest},
+			}
+		}
+
+		text = text[common:]
+		n = child
+	}
+	return n
+}
+
+// Search the remaining path below the node. Static children are tried before
+// the parameter children and those before the catch-all child, backtracking
+// whenever a branch has no route for the request method, and the values
+// captured along the selected branch are kept in the match.
+func (n *node) lookup(path string, m *match) bool {
+	if path == "" && n.selectRoute(m) {
+		return true
+	}
+
+	if path != "" {
+		if m.fold {
+			if n.lookupFold(path, m) {
+				return true
+			}
+		} else if idx := strings.IndexByte(n.indices, path[0]); idx >= 0 {
+			child := n.children[idx]
+			if strings.HasPrefix(path, child.prefix) &&
+				child.lookup(path[len(child.prefix):], m) {
+				return true
+			}
+		}
+
+		end := strings.IndexByte(path, '/')
+		if end < 0 {
+			end = len(path)
+		}
+		for _, child := range n.params {
+			if child.lookupParam(path, end, m) {
+				return true
+			}
+		}
+	}
+
+	if n.catchAll != nil {
+		m.values = append(m.values, path)
+		if n.catchAll.selectRoute(m) {
+			return true
+		}
+		m.values = m.values[:len(m.values)-1]
+	}
+
+	return false
+}
+
+// Try the static children comparing their text case-insensitively, writing the
+// registered text into the canonical path while a branch is being matched.
+func (n *node) lookupFold(path string, m *match) bool {
+	offset := len(m.canonical) - len(path)
+
+	for _, child := range n.children {
+		size := len(child.prefix)
+		if len(path) < size || !strings.EqualFold(path[:size], child.prefix) {
+			continue
+		}
+
+		copy(m.canonical[offset:], child.prefix)
+		if child.lookup(path[size:], m) {
+			return true
+		}
+		copy(m.canonical[offset:], path[:size])
+	}
+	return false
+}
+
+// Capture the value of a parameter node from the start of the path, the
+// segment ending at end. A parameter followed by a literal in its segment
+// takes the shortest value for which the rest of the route matches, otherwise
+// it takes the whole segment.
+func (n *node) lookupParam(path string, end int, m *match) bool {
+	start := end
+	if n.partial {
+		start = 1
+	}
+
+	for idx := start; idx <= end && idx > 0; idx++ {
+		if idx < end && !n.startsChild(path[idx], m.fold) {
+			continue
+		}
+		if n.check != nil && !n.check(path[:idx]) {
+			continue
+		}
+		m.values = append(m.values, path[:idx])
+		if n.lookup(path[idx:], m) {
+			return true
+		}
+		m.values = m.values[:len(m.values)-1]
+	}
+	return false
+}
+
+// Check whether a static child of the node starts with the byte, in any
+// letter case when fold is set.
+func (n *node) startsChild(c byte, fold bool) bool {
+	if strings.IndexByte(n.indices, c) >= 0 {
+		return true
+	}
+	if !fold {
+		return false
+	}
+	switch {
+	case c >= 'a' && c <= 'z':
+		return strings.IndexByte(n.indices, c-'a'+'A') >= 0
+	case c >= 'A' && c <= 'Z':
+		return strings.IndexByte(n.indices, c-'A'+'a') >= 0
+	}
+	return false
+}
+
+// Select the route registered on the node for the request method among the
+// ones accepting the request host and matchers, recording the methods of those
+// routes as allowed when none matches.
+func (n *node) selectRoute(m *match) bool {
+	for _, route := range n.routes {
+		if !route.allows(m.method) {
+			continue
+		}
+		if values, ok := route.accept(m.req); ok {
+			m.route, m.hostValues = route, values
+			return true
+		}
+	}
+
+	for _, route := range n.routes {
+		if _, ok := route.accept(m.req); !ok {
+			continue
+		}
+		for _, method := range route.methods {
+			if !containsString(m.allowed, method) {
+				m.allowed = append(m.allowed, method)
+			}
+		}
+	}
+	return false
+}
+
+// Add a route to the node, keeping the routes in the order they are tried,
+// as defined by Route.before.
+func (n *node) addRoute(route *Route) {
+	idx := len(n.routes)
+	for idx > 0 && route.before(n.routes[idx-1]) {
+		idx--
+	}
+	n.routes = append(n.routes, nil)
+	copy(n.routes[idx+1:], n.routes[idx:])
+	n.routes[idx] = route
+}
+
+// Length of the prefix shared by both strings.
+func commonPrefix(a, b string) int {
+	max := len(a)
+	if len(b) < max {
+		max = len(b)
+	}
+	i := 0
+	for i < max && a[i] == b[i] {
+		i++
+	}
+	return i
+}
+
+// Check whether the list contains the value.
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,131 @@
+package bellt
+
+import (
+	"reflect"
+	"testing"
+)
+
+// Parse a pattern known to be valid, for tests building the tree by hand.
+func tokenize(pattern string) []token {
+	tokens, err := parsePattern("Route", pattern)
+	if err != nil {
+		panic(err)
+	}
+	return tokens
+}
+
+func TestParsePattern(t *testing.T) {
+	got := tokenize("/user/{id}/posts/{postID:[0-9]{2,}}")
+	want := []token{
+		{text: "/user/"},
+		{text: "id", param: true},
+		{text: "/posts/"},
+		{text: "postID", param: true, constraint: "[0-9]{2,}"},
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("wrong tokens: got %v want %v", got, want)
+	}
+}
+
+func TestTreeLookup(t *testing.T) {
+	var root node
+
+	paths := []string{
+		"/user",
+		"/users",
+		"/user/{id}",
+		"/user/{id}/posts/{postID}",
+		"/user/new",
+		"/api/check",
+	}
+	for _, path := range paths {
+		leaf := root.insert(tokenize(path))
+		leaf.routes = append(leaf.routes, &Route{Path: path,
+			methods: []string{"GET"}})
+	}
+
+	cases := []struct {
+		path   string
+		want   string
+		values []string
+	}{
+		{"/user", "/user", nil},
+		{"/users", "/users", nil},
+		{"/user/123", "/user/{id}", []string{"123"}},
+		{"/user/new", "/user/new", nil},
+		{"/user/123/posts/9", "/user/{id}/posts/{postID}",
+			[]string{"123", "9"}},
+		{"/api/check", "/api/check", nil},
+		{"/user/", "", nil},
+		{"/user/123/posts", "", nil},
+		{"/api", "", nil},
+	}
+
+	for _, c := range cases {
+		m := match{method: "GET"}
+		found := root.lookup(c.path, &m)
+		if c.want == "" {
+			if found {
+				t.Errorf("%s: unexpected match %v", c.path, m.route.Path)
+			}
+			continue
+		}
+		if !found {
+			t.Errorf("%s: route not found", c.path)
+			continue
+		}
+		if m.route.Path != c.want {
+			t.Errorf("%s: wrong route: got %v want %v", c.path,
+				m.route.Path, c.want)
+		}
+		if !reflect.DeepEqual(m.values, c.values) {
+			t.Errorf("%s: wrong values: got %v want %v", c.path, m.values,
+				c.values)
+		}
+	}
+}
+
+func TestTreeLookupMethods(t *testing.T) {
+	var root node
+
+	routes := []*Route{
+		{Path: "/user/new", methods: []string{"GET"}},
+		{Path: "/user/{id}", methods: []string{"POST", "PUT"}},
+		{Path: "/user/{id}", methods: []string{"DELETE"}},
+	}
+	for _, route := range routes {
+		leaf := root.insert(tokenize(route.Path))
+		leaf.routes = append(leaf.routes, route)
+	}
+
+	m := match{method: "POST"}
+	if !root.lookup("/user/new", &m) || m.route != routes[1] {
+		t.Errorf("lookup did not fall back to the parameter route")
+	}
+
+	m = match{method: "PATCH"}
+	if root.lookup("/user/new", &m) {
+		t.Errorf("unexpected match %v", m.route.Path)
+	}
+	want := []string{"GET", "POST", "PUT", "DELETE"}
+	if !reflect.DeepEqual(m.allowed, want) {
+		t.Errorf("wrong allowed methods: got %v want %v", m.allowed, want)
+	}
+}
+
+func TestTreeStaticLookupAllocs(t *testing.T) {
+	var root node
+	for _, path := range []string{"/api/check", "/api/{id}"} {
+		leaf := root.insert(tokenize(path))
+		leaf.routes = append(leaf.routes, &Route{methods: []string{"GET"}})
+	}
+
+	allocs := testing.AllocsPerRun(100, func() {
+		m := match{method: "GET"}
+		root.lookup("/api/check", &m)
+	})
+	if allocs != 0 {
+		t.Errorf("static lookup allocates: got %v want 0", allocs)
+	}
+}
@@ -0,0 +1,169 @@
+// Copyright 2019 Guilherme Caruso. All rights reserved.
+// Use of this source code is governed by a MIT License
+// license that can be found in the LICENSE file.
+
+package bellt
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Name sets the name used to build the URL of the route through Router.URL.
+// Names are unique within a Router; a name already given to another route is
+// reported by Err.
+func (route *Route) Name(name string) *Route {
+	root := route.router
+	if root == nil {
+		return route
+	}
+
+	root.mu.Lock()
+	defer root.mu.Unlock()
+
+	if name == "" {
+		root.errs = append(root.errs, fmt.Errorf("Route %s name must not "+
+			"be empty", route.pattern()))
+		return route
+	}
+	if other, ok := root.names[name]; ok && other != route {
+		root.errs = append(root.errs, fmt.Errorf("Route name %s is already "+
+			"used by %s", name, other.pattern()))
+		return route
+	}
+
+	if root.names == nil {
+		root.names = make(map[string]*Route)
+	}
+	delete(root.names, route.name)
+	root.names[name] = route
+	route.name = name
+	return route
+}
+
+// URL builds the URL of the route with the name, replacing its parameters by
+// the values given as name and value pairs, host parameters included:
+//
+//	u, err := router.URL("user", "id", "42")
+//	// u.String() == "/user/42"
+//
+// Every parameter of the route must be given a value accepted by its
+// constraint. Values are escaped as needed, and only catch-all parameters may
+// hold slashes. The host of the URL is set for routes restricted to a host.
+func (r *Router) URL(name string, pairs ...string) (*url.URL, error) {
+	root := r.base()
+	root.mu.RLock()
+	route, ok := root.names[name]
+	root.mu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("Route name %s is not registered", name)
+	}
+	if len(pairs)%2 != 0 {
+		return nil, fmt.Errorf("URL parameters %v must be given as name and "+
+			"value pairs", pairs)
+	}
+
+	values := make(map[string]string, len(pairs)/2)
+	for idx := 0; idx < len(pairs); idx += 2 {
+		values[pairs[idx]] = pairs[idx+1]
+	}
+
+	u := &url.URL{}
+	if route.host != nil {
+		host, err := expandPattern(route, route.host.tokens, values, ".:/")
+		if err != nil {
+			return nil, err
+		}
+		u.Host = host
+	}
+
+	path, err := expandPattern(route, route.tokens, values, "/")
+	if err != nil {
+		return nil, err
+	}
+	u.Path = path
+
+	for idx := 0; idx < len(pairs); idx += 2 {
+		if !route.hasParam(pairs[idx]) {
+			return nil, fmt.Errorf("Route %s has no parameter {%s}",
+				route.pattern(), pairs[idx])
+		}
+	}
+	return u, nil
+}
+
+// Internal function that replaces the parameters of the tokens by their
+// values, checking them against the constraints of the parameters. Values
+// other than the ones of catch-all parameters must not be empty nor hold any
+// of the separators, nor the literal following them in their segment, since
+// parameters take the shortest value followed by it. No value may hold empty,
+// "." or ".." segments, which clients would resolve to another path.
+func expandPattern(route *Route, tokens []token, values map[string]string,
+	separators string) (string, error) {
+	var expanded strings.Builder
+	for idx, tk := range tokens {
+		if !tk.param {
+			expanded.WriteString(tk.text)
+			continue
+		}
+
+		value, ok := values[tk.text]
+		if !ok {
+			return "", fmt.Errorf("Route %s requires the parameter {%s}",
+				route.pattern(), tk.text)
+		}
+		if !tk.catchAll && (value == "" ||
+			strings.ContainsAny(value, separators) ||
+			containsLiteral(value, tokens[idx+1:], separators)) ||
+			!cleanSegments(value) || tk.check != nil && !tk.check(value) {
+			return "", fmt.Errorf("Route %s does not accept %q for the "+
+				"parameter {%s}", route.pattern(), value, tk.text)
+		}
+		expanded.WriteString(value)
+	}
+	return expanded.String(), nil
+}
+
+// Internal function that checks whether the value holds the literal text
+// following its parameter, up to the end of its segment.
+func containsLiteral(value string, next []token, separators string) bool {
+	if len(next) == 0 || next[0].param {
+		return false
+	}
+
+	literal := next[0].text
+	if end := strings.IndexAny(literal, separators); end >= 0 {
+		literal = literal[:end]
+	}
+	return literal != "" && strings.Contains(value, literal)
+}
+
+// Internal function that checks whether every segment of a non-empty value
+// is a name, neither empty nor "." or "..".
+func cleanSegments(value string) bool {
+	if value == "" {
+		return true
+	}
+	for _, segment := range strings.Split(value, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+// Internal method that checks whether the route has a parameter, in its host
+// or path, with the name.
+func (route *Route) hasParam(name string) bool {
+	if route.host != nil && containsString(route.host.names, name) {
+		return true
+	}
+	for _, tk := range route.tokens {
+		if tk.param && tk.text == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,123 @@
+// Copyright 2019 Guilherme Caruso. All rights reserved.
+// Use of this source code is governed by a MIT License
+// license that can be found in the LICENSE file.
+
+package bellt
+
+import (
+	"net/http"
+	"reflect"
+	"regexp"
+	"runtime"
+	"strings"
+)
+
+// Expression matching the suffix given by the runtime to anonymous functions,
+// such as the middlewares returned by a constructor.
+var closureSuffix = regexp.MustCompile(`(\.func\d+)+(\.\d+)*$`)
+
+// RouteInfo describes a route registered on a Router, as listed by Routes.
+// Pattern is the full path pattern, group prefix included, and Host the host
+// pattern, empty when the route answers any host. Methods is empty for
+// mounted handlers, which answer every method below Pattern. Middleware holds
+// the names of the middlewares of the route, outermost first.
+type RouteInfo struct {
+	Name       string
+	Host       string
+	Pattern    string
+	Methods    []string
+	Group      string
+	Middleware []string
+	Meta       map[string]interface{}
+}
+
+// Meta sets metadata on the route, such as a description or the permission it
+// requires, listed by Router.Routes.
+func (route *Route) Meta(key string, value interface{}) *Route {
+	if route.router != nil {
+		route.router.mu.Lock()
+		defer route.router.mu.Unlock()
+	}
+
+	if route.meta == nil {
+		route.meta = make(map[string]interface{})
+	}
+	route.meta[key] = value
+	return route
+}
+
+// Routes returns the description of every route registered on the Router, in
+// registration order. The descriptions are copies, so changing them does not
+// affect the Router.
+func (r *Router) Routes() []RouteInfo {
+	root := r.base()
+	root.mu.RLock()
+	defer root.mu.RUnlock()
+
+	infos := make([]RouteInfo, len(root.routes))
+	for idx, route := range root.routes {
+		infos[idx] = route.info()
+	}
+	return infos
+}
+
+// CurrentRoute returns the description of the route matching the request,
+// available to its handler and to the middlewares added by Use. It reports
+// false outside a matched route, such as in the middlewares added by Pre.
+func CurrentRoute(r *http.Request) (RouteInfo, bool) {
+	current, ok := r.Context().Value(routeKey{}).(*routeContext)
+	if !ok {
+		return RouteInfo{}, false
+	}
+
+	route := current.route
+
+	route.router.mu.RLock()
+	defer route.router.mu.RUnlock()
+	return route.info(), true
+}
+
+// Walk calls the function for every route registered on the Router, in the
+// order of Routes, stopping at the first error, which is returned. Routes may
+// be registered by the function, though they are not visited.
+func (r *Router) Walk(fn func(route RouteInfo) error) error {
+	for _, info := range r.Routes() {
+		if err := fn(info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Internal method that describes the route.
+func (route *Route) info() RouteInfo {
+	info := RouteInfo{
+		Name:    route.name,
+		Host:    route.hostText(),
+		Pattern: route.Path,
+		Methods: append([]string(nil), route.methods...),
+		Group:   route.group,
+	}
+
+	for _, layer := range route.middleware() {
+		info.Middleware = append(info.Middleware, layer.name)
+	}
+	if route.meta != nil {
+		info.Meta = make(map[string]interface{}, len(route.meta))
+		for key, value := range route.meta {
+			info.Meta[key] = value
+		}
+	}
+	return info
+}
+
+// Function that returns the name of a function with the name of its package,
+// such as "bellt.Logger". Anonymous functions are named after the function
+// declaring them.
+func funcName(fn interface{}) string {
+	name := runtime.FuncForPC(reflect.ValueOf(fn).Pointer()).Name()
+	if slash := strings.LastIndexByte(name, '/'); slash >= 0 {
+		name = name[slash+1:]
+	}
+	return closureSuffix.ReplaceAllString(name, "")
+}